@@ -4,7 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
+	"sort"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/mattermost/mattermost/server/public/model"
@@ -90,9 +90,9 @@ func (m *BookmarkActionModel) SelectedAction() BookmarkAction {
 
 func RunBookmarkMenu() BookmarkAction {
 	model := NewBookmarkActionModel()
-	p := tea.NewProgram(model)
+	p := tea.NewProgram(model, tea.WithOutput(consoleOutput()))
 	if _, err := p.Run(); err != nil {
-		fmt.Printf("Error running bookmark menu: %v\n", err)
+		fmt.Fprintf(consoleOutput(), "Error running bookmark menu: %v\n", err)
 		return BookmarkAbort
 	}
 	return model.SelectedAction()
@@ -121,7 +121,7 @@ func HasExistingBookmarks(mmClient model.Client4, channelID string) (bool, error
 	return true, nil
 }
 
-func DeleteExistingBookmarks(mmClient model.Client4, channelID string) error {
+func DeleteExistingBookmarks(mmClient model.Client4, channelID string, snapshot *ChannelSnapshot) error {
 	DebugPrint("Deleting existing bookmarks")
 
 	ctx := context.Background()
@@ -147,17 +147,65 @@ func DeleteExistingBookmarks(mmClient model.Client4, channelID string) error {
 		}
 		if response.StatusCode != 200 && response.StatusCode != 201 {
 			LogMessage(errorLevel, "Function call to DeleteChannelBookmark returned bad HTTP response")
+			return errors.New("bad HTTP response")
 		}
-
+		snapshot.DeletedBookmarkIDs = append(snapshot.DeletedBookmarkIDs, bookmark.Id)
+		snapshot.Save()
 	}
 
 	return nil
 }
 
-func CreateBookmarks(mmClient model.Client4, channelID string, config *Config) error {
+// fileBookmarks returns the channel's existing file bookmarks, by file ID and by display name
+func fileBookmarks(mmClient model.Client4, channelID string) (map[string]string, map[string]string, error) {
+	bookmarks, response, err := mmClient.ListChannelBookmarksForChannel(context.Background(), channelID, 0)
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve bookmarks: "+err.Error())
+		return nil, nil, err
+	}
+	if response.StatusCode != 200 && response.StatusCode != 201 {
+		LogMessage(errorLevel, "Function call to ListChannelBookmarksForChannel returned bad HTTP response")
+		return nil, nil, errors.New("bad HTTP response")
+	}
+
+	byFile := make(map[string]string)
+	byName := make(map[string]string)
+	for _, bookmark := range bookmarks {
+		if bookmark.Type == model.ChannelBookmarkFile && bookmark.FileId != "" {
+			byFile[bookmark.FileId] = bookmark.Id
+			byName[bookmark.DisplayName] = bookmark.Id
+		}
+	}
+	return byFile, byName, nil
+}
+
+// updateBookmarkFile points an existing file bookmark at a new upload.  The server replaces the bookmark with a new
+// one, whose ID is returned.
+func updateBookmarkFile(mmClient model.Client4, channelID string, bookmarkID string, fileID string) (string, error) {
+	updated, response, err := mmClient.UpdateChannelBookmark(context.Background(), channelID, bookmarkID, &model.ChannelBookmarkPatch{FileId: &fileID})
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to update bookmark: "+err.Error())
+		return "", err
+	}
+	if response.StatusCode != 200 || updated.Updated == nil {
+		LogMessage(errorLevel, "Function call to UpdateChannelBookmark returned bad HTTP response")
+		return "", errors.New("bad HTTP response")
+	}
+	return updated.Updated.Id, nil
+}
+
+// CreateBookmarks adds every bookmark in the config to the channel, recording each one in the snapshot as it's
+// created.  Files are uploaded first: a file which is already bookmarked with the same content is left alone, and
+// an existing file bookmark with the same name is updated to the new upload rather than duplicated.
+// It returns the ID of the bookmark for each entry in the config.
+func CreateBookmarks(mmClient model.Client4, channelID string, config *Config, snapshot *ChannelSnapshot) ([]string, error) {
 	DebugPrint("Creating bookmarks")
 
 	ctx := context.Background()
+	var existingFiles, existingNames map[string]string
+	bookmarkIDs := make([]string, 0, len(config.Bookmarks))
 
 	for _, bookmark := range config.Bookmarks {
 		bookmarkPayload := &model.ChannelBookmark{
@@ -165,25 +213,69 @@ func CreateBookmarks(mmClient model.Client4, channelID string, config *Config) e
 			DisplayName: bookmark.DisplayName,
 			LinkUrl:     bookmark.LinkURL,
 			Emoji:       bookmark.Emoji,
-			Type:        "link",
+			Type:        model.ChannelBookmarkLink,
 		}
 
-		_, response, err := mmClient.CreateChannelBookmark(ctx, bookmarkPayload)
+		if bookmark.isFile() {
+			fileID, err := UploadChannelFile(mmClient, channelID, bookmark.Path)
+			if err != nil {
+				return bookmarkIDs, err
+			}
+
+			if existingFiles == nil {
+				if existingFiles, existingNames, err = fileBookmarks(mmClient, channelID); err != nil {
+					return bookmarkIDs, err
+				}
+			}
+			if bookmarkID, found := existingFiles[fileID]; found {
+				LogMessage(infoLevel, "Bookmark for "+bookmark.Path+" is already up to date")
+				bookmarkIDs = append(bookmarkIDs, bookmarkID)
+				continue
+			}
+			if bookmarkID, found := existingNames[bookmark.DisplayName]; found {
+				LogMessage(infoLevel, "Updating bookmark "+bookmark.DisplayName+" to the new version of "+bookmark.Path)
+				updatedID, err := updateBookmarkFile(mmClient, channelID, bookmarkID, fileID)
+				if err != nil {
+					return bookmarkIDs, err
+				}
+				bookmarkIDs = append(bookmarkIDs, updatedID)
+				snapshot.DeletedBookmarkIDs = append(snapshot.DeletedBookmarkIDs, bookmarkID)
+				snapshot.CreatedBookmarkIDs = append(snapshot.CreatedBookmarkIDs, updatedID)
+				snapshot.Save()
+				continue
+			}
+
+			bookmarkPayload.LinkUrl = ""
+			bookmarkPayload.FileId = fileID
+			bookmarkPayload.Type = model.ChannelBookmarkFile
+		} else {
+			imageURL, err := BookmarkImageURL(mmClient, channelID, bookmark)
+			if err != nil {
+				return bookmarkIDs, err
+			}
+			bookmarkPayload.ImageUrl = imageURL
+		}
+
+		created, response, err := mmClient.CreateChannelBookmark(ctx, bookmarkPayload)
 
 		if err != nil {
 			LogMessage(errorLevel, "Failed to create bookmark: "+err.Error())
-			return err
+			return bookmarkIDs, err
 		}
 		if response.StatusCode != 200 && response.StatusCode != 201 {
 			LogMessage(errorLevel, "Function call to CreateChannelBookmark returned bad HTTP response")
-			return errors.New("bad HTTP response")
+			return bookmarkIDs, errors.New("bad HTTP response")
 		}
+
+		bookmarkIDs = append(bookmarkIDs, created.Id)
+		snapshot.CreatedBookmarkIDs = append(snapshot.CreatedBookmarkIDs, created.Id)
+		snapshot.Save()
 	}
 
-	return nil
+	return bookmarkIDs, nil
 }
 
-func ProcessChannelBookmarks(mmClient model.Client4, channelID string, config *Config) {
+func ProcessChannelBookmarks(mmClient model.Client4, channelID string, config *Config, snapshot *ChannelSnapshot) {
 	DebugPrint("Processing channel bookmarks")
 
 	if len(config.Bookmarks) == 0 {
@@ -195,7 +287,7 @@ func ProcessChannelBookmarks(mmClient model.Client4, channelID string, config *C
 
 	if err != nil {
 		LogMessage(errorLevel, "Failed to retrieve existing bookmarks.  Aborting.")
-		os.Exit(41)
+		exit(41)
 	}
 
 	if hasBookmarks {
@@ -204,10 +296,10 @@ func ProcessChannelBookmarks(mmClient model.Client4, channelID string, config *C
 		switch action {
 		case BookmarkReplace:
 			LogMessage(infoLevel, "Replacing existing bookbarks")
-			err = DeleteExistingBookmarks(mmClient, channelID)
+			err = DeleteExistingBookmarks(mmClient, channelID, snapshot)
 			if err != nil {
 				LogMessage(errorLevel, "Failed to delete existing bookmarks.  Aborting.")
-				os.Exit(45)
+				exit(45)
 			}
 		case BookmarkAppend:
 			LogMessage(infoLevel, "Appending bookmarks to existing")
@@ -216,11 +308,101 @@ func ProcessChannelBookmarks(mmClient model.Client4, channelID string, config *C
 			return
 		}
 	}
-	err = CreateBookmarks(mmClient, channelID, config)
+	bookmarkIDs, err := CreateBookmarks(mmClient, channelID, config, snapshot)
 
 	if err != nil {
 		LogMessage(errorLevel, "Failed to create bookmarks.  Aborting.")
-		os.Exit(42)
+		exit(42)
+	}
+
+	err = SortBookmarks(mmClient, channelID, config.Bookmarks, bookmarkIDs)
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to put the bookmarks in order.  Aborting.")
+		exit(46)
 	}
 
 }
+
+// SortBookmarks moves each bookmark with an explicit order in the config to that position in the channel.  The
+// other bookmarks, whether they already existed or were just created, keep their relative order around them.
+// bookmarkIDs holds the ID of the bookmark for each entry in bookmarks.
+func SortBookmarks(mmClient model.Client4, channelID string, bookmarks []Bookmark, bookmarkIDs []string) error {
+	positions := make(map[string]int)
+	for i, bookmark := range bookmarks {
+		if bookmark.Order > 0 && i < len(bookmarkIDs) {
+			positions[bookmarkIDs[i]] = bookmark.Order
+		}
+	}
+	if len(positions) == 0 {
+		return nil
+	}
+
+	DebugPrint("Sorting bookmarks")
+
+	ctx := context.Background()
+
+	current, response, err := mmClient.ListChannelBookmarksForChannel(ctx, channelID, 0)
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve bookmarks: "+err.Error())
+		return err
+	}
+	if response.StatusCode != 200 && response.StatusCode != 201 {
+		LogMessage(errorLevel, "Function call to ListChannelBookmarksForChannel returned bad HTTP response")
+		return errors.New("bad HTTP response")
+	}
+	sort.SliceStable(current, func(a, b int) bool { return current[a].SortOrder < current[b].SortOrder })
+
+	// Place the ordered bookmarks first, lowest order first, then fill the gaps with the rest
+	var ordered, unordered []string
+	for _, bookmark := range current {
+		if _, found := positions[bookmark.Id]; found {
+			ordered = append(ordered, bookmark.Id)
+		} else {
+			unordered = append(unordered, bookmark.Id)
+		}
+	}
+	sort.SliceStable(ordered, func(a, b int) bool { return positions[ordered[a]] < positions[ordered[b]] })
+
+	desired := make([]string, len(current))
+	for _, id := range ordered {
+		slot := positions[id] - 1
+		if slot >= len(desired) {
+			slot = len(desired) - 1
+		}
+		for desired[slot] != "" && slot < len(desired)-1 {
+			slot++
+		}
+		for desired[slot] != "" {
+			slot--
+		}
+		desired[slot] = id
+	}
+	for i := range desired {
+		if desired[i] == "" {
+			desired[i], unordered = unordered[0], unordered[1:]
+		}
+	}
+
+	for i, id := range desired {
+		if current[i].Id == id {
+			continue
+		}
+
+		DebugPrint(fmt.Sprintf("Moving bookmark %s to position %d", id, i+1))
+		current, response, err = mmClient.UpdateChannelBookmarkSortOrder(ctx, channelID, id, int64(i))
+
+		if err != nil {
+			LogMessage(errorLevel, "Failed to move bookmark "+id+": "+err.Error())
+			return err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(errorLevel, "Function call to UpdateChannelBookmarkSortOrder returned bad HTTP response")
+			return errors.New("bad HTTP response")
+		}
+		sort.SliceStable(current, func(a, b int) bool { return current[a].SortOrder < current[b].SortOrder })
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Channel bookmarks first appeared in 9.11 behind a feature flag, which was on by default from 10.1
+const (
+	bookmarksMinMajor, bookmarksMinMinor = 9, 11
+	bookmarksGAMajor, bookmarksGAMinor   = 10, 1
+)
+
+// ServerCapabilities describes the features of the server which affect what 'apply' can do
+type ServerCapabilities struct {
+	Version         string
+	Bookmarks       bool
+	BookmarksReason string
+	SiteURL         string
+}
+
+// parseServerVersion returns the major and minor version from a version string such as "10.5.0" or the
+// "10.5.0.12345.abc.true" sent in the X-Version-Id header
+func parseServerVersion(version string) (int, int, error) {
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("unrecognised server version %q", version)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unrecognised server version %q", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unrecognised server version %q", version)
+	}
+	return major, minor, nil
+}
+
+func versionAtLeast(major, minor, wantMajor, wantMinor int) bool {
+	return major > wantMajor || (major == wantMajor && minor >= wantMinor)
+}
+
+// DetectCapabilities asks the server for its version and client config.  The client config is available without
+// logging in, so this also works before the token has been checked.
+func DetectCapabilities(mmClient model.Client4) (*ServerCapabilities, error) {
+	DebugPrint("Detecting server capabilities")
+
+	ctx := context.Background()
+	etag := ""
+
+	clientConfig, response, err := mmClient.GetOldClientConfig(ctx, etag)
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve client config: "+err.Error())
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(errorLevel, "Function call to GetOldClientConfig returned bad HTTP response")
+		return nil, errors.New("bad HTTP response")
+	}
+
+	capabilities := &ServerCapabilities{
+		Version: response.ServerVersion,
+		SiteURL: strings.TrimRight(clientConfig["SiteURL"], "/"),
+	}
+	if capabilities.Version == "" {
+		capabilities.Version = clientConfig["Version"]
+	}
+
+	major, minor, err := parseServerVersion(capabilities.Version)
+	switch {
+	case err != nil:
+		capabilities.BookmarksReason = err.Error()
+	case !versionAtLeast(major, minor, bookmarksMinMajor, bookmarksMinMinor):
+		capabilities.BookmarksReason = fmt.Sprintf("server version %d.%d is older than %d.%d", major, minor, bookmarksMinMajor, bookmarksMinMinor)
+	case clientConfig["FeatureFlagChannelBookmarks"] == "false":
+		capabilities.BookmarksReason = "the ChannelBookmarks feature flag is disabled"
+	case clientConfig["FeatureFlagChannelBookmarks"] == "true" || versionAtLeast(major, minor, bookmarksGAMajor, bookmarksGAMinor):
+		capabilities.Bookmarks = true
+	default:
+		capabilities.BookmarksReason = "the ChannelBookmarks feature flag is not enabled"
+	}
+
+	DebugPrint(fmt.Sprintf("Server version %s, bookmarks supported: %t", capabilities.Version, capabilities.Bookmarks))
+	return capabilities, nil
+}
+
+// serverCapabilities is detected once per run, the first time it's needed
+var serverCapabilities *ServerCapabilities
+
+// currentCapabilities returns the server's capabilities, detecting them on first use.  If detection fails we
+// assume bookmarks are available, which is how earlier versions behaved.
+func currentCapabilities(mmClient model.Client4) *ServerCapabilities {
+	if serverCapabilities == nil {
+		capabilities, err := DetectCapabilities(mmClient)
+		if err != nil {
+			LogMessage(warningLevel, "Unable to detect server capabilities, so assuming channel bookmarks are available")
+			capabilities = &ServerCapabilities{Bookmarks: true}
+		}
+		serverCapabilities = capabilities
+	}
+	return serverCapabilities
+}
+
+// bookmarksAvailable reports whether the server supports channel bookmarks
+func bookmarksAvailable(mmClient model.Client4) bool {
+	return currentCapabilities(mmClient).Bookmarks
+}
+
+// withoutBookmarks returns a copy of the config with the bookmark links moved into the header and pinned post,
+// for servers which don't support bookmarks.  Links already in those places aren't duplicated.
+func withoutBookmarks(config *Config, steps applySteps) *Config {
+	updated := *config
+	updated.Bookmarks = nil
+
+	inHeader := make(map[string]bool, len(config.HeaderLinks))
+	for _, link := range config.HeaderLinks {
+		inHeader[normaliseLinkURL(link.LinkURL)] = true
+	}
+	inPinned := make(map[string]bool, len(config.Resources))
+	for _, resource := range config.Resources {
+		inPinned[normaliseLinkURL(resource.URL)] = true
+	}
+
+	updated.HeaderLinks = append([]Bookmark{}, config.HeaderLinks...)
+	updated.Resources = append([]Resource{}, config.Resources...)
+	for _, bookmark := range config.Bookmarks {
+		if bookmark.isFile() {
+			LogMessage(warningLevel, "File bookmark "+bookmark.DisplayName+" can't be shown without channel bookmarks, and will be skipped")
+			continue
+		}
+		key := normaliseLinkURL(bookmark.LinkURL)
+		if steps.Header && !inHeader[key] {
+			updated.HeaderLinks = append(updated.HeaderLinks, Bookmark{DisplayName: bookmark.DisplayName, LinkURL: bookmark.LinkURL})
+		}
+		if steps.Pinned && !inPinned[key] {
+			updated.Resources = append(updated.Resources, Resource{DisplayName: bookmark.DisplayName, URL: bookmark.LinkURL})
+		}
+	}
+	return &updated
+}
+
+// AdaptToServer turns off the steps the server can't support, moving their links elsewhere where possible.  It
+// returns a description of each feature which was skipped.
+func AdaptToServer(mmClient model.Client4, steps *applySteps, channels []channelTarget) []string {
+	var skipped []string
+
+	if steps.Bookmarks && !bookmarksAvailable(mmClient) {
+		skipped = append(skipped, "channel bookmarks ("+serverCapabilities.BookmarksReason+")")
+		steps.Bookmarks = false
+
+		if steps.Header || steps.Pinned {
+			LogMessage(warningLevel, "Channel bookmarks aren't available on this server ("+serverCapabilities.BookmarksReason+").  Their links will be added to the header and pinned post instead.")
+			for i := range channels {
+				channels[i].Config = withoutBookmarks(channels[i].Config, *steps)
+			}
+		} else {
+			LogMessage(warningLevel, "Channel bookmarks aren't available on this server ("+serverCapabilities.BookmarksReason+"), and the header and pinned post steps weren't selected, so their links will be skipped.")
+		}
+	}
+
+	return skipped
+}
@@ -5,7 +5,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/mattermost/mattermost/server/public/model"
@@ -35,27 +34,34 @@ func ChannelHeaderExists(mmClient model.Client4, channelID string) (bool, error)
 	return false, nil
 }
 
-func CreateChannelHeader(mmClient model.Client4, channelID string, config *Config) error {
-
-	DebugPrint("Creating channel header")
-
-	ctx := context.Background()
-
+// BuildChannelHeader renders the channel header from the config
+func BuildChannelHeader(config *Config) string {
 	channelHeader := "Important Data (hover for expanded view)\n\n"
 
 	for _, person := range config.Team {
-		personRow := fmt.Sprintf("%s - [%s](%s)\n\n", person.Role, person.Name, person.Email)
+		personRow := BuildContactLine(config, person) + "\n\n"
 		channelHeader += personRow
 	}
 
 	channelHeader += "| Key Resources |\n"
 	channelHeader += "| -- |\n"
 
-	for _, bookmark := range config.Bookmarks {
+	for _, bookmark := range config.HeaderLinks {
 		bookmarkRow := fmt.Sprintf("|[%s](%s)|\n", bookmark.DisplayName, bookmark.LinkURL)
 		channelHeader += bookmarkRow
 	}
 
+	return channelHeader
+}
+
+func CreateChannelHeader(mmClient model.Client4, channelID string, config *Config) error {
+
+	DebugPrint("Creating channel header")
+
+	ctx := context.Background()
+
+	channelHeader := BuildChannelHeader(config)
+
 	channelPayload := &model.ChannelPatch{
 		Header: &channelHeader,
 	}
@@ -74,32 +80,37 @@ func CreateChannelHeader(mmClient model.Client4, channelID string, config *Confi
 	return nil
 }
 
-func ProcessChannelHeader(mmClient model.Client4, MattermostChannel string, config *Config) {
+func ProcessChannelHeader(mmClient model.Client4, MattermostChannel string, config *Config, snapshot *ChannelSnapshot) {
 	DebugPrint("Processing channel header")
 
-	if len(config.Bookmarks) == 0 {
-		LogMessage(warningLevel, "No bookmarks found in JSON file")
+	if len(config.HeaderLinks) == 0 {
+		LogMessage(warningLevel, "No header links found in JSON file")
 		return
 	}
-	numBookmarks := fmt.Sprintf("Found %d bookmarks", len(config.Bookmarks))
+	numBookmarks := fmt.Sprintf("Found %d header links", len(config.HeaderLinks))
 	DebugPrint(numBookmarks)
 
 	hasHeader, err := ChannelHeaderExists(mmClient, MattermostChannel)
 	if err != nil {
 		LogMessage(errorLevel, "Unable to validate if channel header exists!  Aborting.")
-		os.Exit(8)
+		exit(8)
 	}
 
 	input := "y"
 
 	if hasHeader {
-		fmt.Printf("A channel header already exists.  Overwrite? (Press Y to confirm, or any other key to abort)")
+		fmt.Fprintf(consoleOutput(), "A channel header already exists.  Overwrite? (Press Y to confirm, or any other key to abort)")
 
-		reader := bufio.NewReader(os.Stdin)
-		input, err = reader.ReadString('\n')
+		answers, err := promptInput()
+		if err != nil {
+			LogMessage(errorLevel, "Unable to open the terminal to read the answer: "+err.Error())
+			exit(9)
+		}
+		input, err = bufio.NewReader(answers).ReadString('\n')
+		answers.Close()
 		if err != nil {
 			LogMessage(errorLevel, "Error reading input.  Aborting.")
-			os.Exit(9)
+			exit(9)
 		}
 	}
 
@@ -109,8 +120,10 @@ func ProcessChannelHeader(mmClient model.Client4, MattermostChannel string, conf
 		err = CreateChannelHeader(mmClient, MattermostChannel, config)
 		if err != nil {
 			LogMessage(errorLevel, "Error creating channel header.  Aborting")
-			os.Exit(31)
+			exit(31)
 		}
+		snapshot.HeaderChanged = true
+		snapshot.Save()
 	} else {
 		LogMessage(infoLevel, "Using existing Channel Header")
 	}
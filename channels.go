@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// ResolveChannelID accepts either a channel ID or a "team-name/channel-name" reference and returns the channel ID
+func ResolveChannelID(mmClient model.Client4, channelRef string) (string, error) {
+	if model.IsValidId(channelRef) {
+		return channelRef, nil
+	}
+
+	teamName, channelName, found := strings.Cut(channelRef, "/")
+	if !found || teamName == "" || channelName == "" {
+		return "", errors.New("channel must be an ID or in the form team-name/channel-name")
+	}
+
+	DebugPrint("Looking up channel " + channelName + " in team " + teamName)
+
+	ctx := context.Background()
+	etag := ""
+
+	channel, response, err := mmClient.GetChannelByNameForTeamName(ctx, channelName, teamName, etag)
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve channel: "+err.Error())
+		return "", err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(errorLevel, "Function call to GetChannelByNameForTeamName returned bad HTTP response")
+		return "", errors.New("bad HTTP response")
+	}
+
+	return channel.Id, nil
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// targetOptions selects the channels to work on, and the config to apply to them
+type targetOptions struct {
+	Channel          string
+	Inventory        string
+	ConfigFilename   string
+	TemplatesChannel string
+}
+
+// channelTarget is a single channel, along with the config rendered for it
+type channelTarget struct {
+	Ref       string
+	ChannelID string
+	Config    *Config
+}
+
+// applySteps controls which parts of the channel 'apply' will change
+type applySteps struct {
+	Pinned    bool
+	Header    bool
+	Bookmarks bool
+
+	// Members is set by -ensure-members, and adds the team contacts to the channel
+	Members bool
+}
+
+// newCommandFlags creates the flag set for a command, including the options shared by every command
+func newCommandFlags(name string, description string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.BoolVar(&debugMode, "debug", debugMode, "Enable debug output")
+	flags.Func("log-format", "Log format: text or json. [Default: text]", setLogFormat)
+	flags.StringVar(&logFile, "log-file", logFile, "Write logs to this file, rotated when it reaches 10MB, instead of the console")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [options]\n", os.Args[0], name)
+		fmt.Fprintln(flags.Output(), description)
+		fmt.Fprintln(flags.Output(), "Options:")
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseCommandFlags parses the command line, and then builds the logger from the logging and output options
+func parseCommandFlags(flags *flag.FlagSet, args []string) {
+	flags.Parse(args)
+	if err := configureLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+}
+
+// addConnectionFlags adds the options used to connect to Mattermost
+func addConnectionFlags(flags *flag.FlagSet, conn *mmConnection) {
+	flags.StringVar(&conn.mmURL, "url", "", "The base URL of the Mattermost instance, e.g. https://chat.example.com/mattermost.  The scheme defaults to "+defaultScheme)
+	flags.StringVar(&conn.mmPort, "port", "", "Override the TCP port in -url")
+	flags.StringVar(&conn.mmScheme, "scheme", "", "Override the HTTP scheme (http/https) in -url")
+	flags.StringVar(&conn.mmToken, "token", "", "The auth token used to connect to Mattermost.  Visible to other users - prefer -token-file or -token-stdin")
+	flags.StringVar(&conn.mmTokenFile, "token-file", "", "Read the auth token from this file")
+	flags.BoolVar(&conn.mmTokenStdin, "token-stdin", false, "Read the auth token from the first line of stdin")
+	flags.StringVar(&conn.mmUsername, "username", "", "Log in with this username or email instead of a token, for servers where tokens are disabled")
+	flags.StringVar(&conn.mmPasswordFile, "password-file", "", "Read the password for -username from this file. [Default: MM_PASSWORD, or prompt]")
+	flags.StringVar(&conn.mmMFACode, "mfa-code", "", "One-time MFA code for -username. [Default: prompt if required]")
+	flags.StringVar(&conn.caCertFile, "ca-cert", "", "PEM bundle of CA certificates to trust, in addition to the system ones, e.g. for an internal CA")
+	flags.StringVar(&conn.clientCertFile, "client-cert", "", "PEM client certificate for servers requiring mutual TLS")
+	flags.StringVar(&conn.clientKeyFile, "client-key", "", "PEM private key for -client-cert")
+	flags.BoolVar(&conn.insecureSkipVerify, "insecure-skip-verify", false, "Don't verify the server's certificate.  Only for test labs!")
+	flags.StringVar(&siteURLOverride, "site-url", "", "The URL users reach Mattermost on, used for permalinks. [Default: the server's SiteURL setting]")
+	flags.StringVar(&conn.mmProfile, "profile", "", "Named server profile, from the profiles file or mmctl credentials, supplying the URL, port, scheme and token")
+}
+
+// addConfigFlags adds the options used to locate and load the config
+func addConfigFlags(flags *flag.FlagSet, target *targetOptions) {
+	flags.StringVar(&target.ConfigFilename, "config", conf_file_default, "Alternative JSON/YAML filename, https:// URL, mattermost://post/<id>, mattermost://file/<id>, mattermost://template/<name>, or '-' to read from stdin. [Default: "+conf_file_default+"]")
+	flags.StringVar(&configChecksum, "config-sha256", "", "Expected SHA-256 checksum of the config.  The run is aborted if it doesn't match")
+	flags.StringVar(&cacheDir, "cache-dir", "", "Directory used to cache configs downloaded from a URL. [Default: user cache directory]")
+	flags.StringVar(&target.TemplatesChannel, "templates-channel", "", "Channel ID (or team-name/channel-name) holding config templates for mattermost://template/<name>")
+}
+
+// addTargetFlags adds the options used to select channels, along with the config options
+func addTargetFlags(flags *flag.FlagSet, target *targetOptions) {
+	flags.StringVar(&target.Channel, "channel", "", "The channel ID to target (available from 'Channel Info' screen), or team-name/channel-name")
+	flags.StringVar(&target.Inventory, "inventory", "", "CSV/TSV file listing channels and per-channel variables")
+	addConfigFlags(flags, target)
+}
+
+// resolveEnvironment fills in anything not supplied on the command line from environment variables, and then
+// from the selected server profile
+func resolveEnvironment(conn *mmConnection, target *targetOptions) {
+	commandLine := *conn
+	if conn.mmURL == "" {
+		conn.mmURL = getEnvWithDefault("MM_URL", "").(string)
+	}
+	if conn.mmPort == "" {
+		conn.mmPort = getEnvWithDefault("MM_PORT", "").(string)
+	}
+	if conn.mmScheme == "" {
+		conn.mmScheme = getEnvWithDefault("MM_SCHEME", "").(string)
+	}
+	if err := resolveToken(conn); err != nil {
+		LogMessage(errorLevel, "Unable to read the Mattermost auth token: "+err.Error())
+		exit(1)
+	}
+	if err := resolveLogin(conn); err != nil {
+		LogMessage(errorLevel, "Unable to read the Mattermost login details: "+err.Error())
+		exit(1)
+	}
+	if err := resolveTLSEnvironment(conn); err != nil {
+		LogMessage(errorLevel, "Invalid TLS options: "+err.Error())
+		exit(1)
+	}
+	if siteURLOverride == "" {
+		siteURLOverride = getEnvWithDefault("MM_SITE_URL", "").(string)
+	}
+	if siteURLOverride != "" {
+		if err := validateSiteURL(siteURLOverride); err != nil {
+			LogMessage(errorLevel, "Invalid -site-url: "+err.Error())
+			exit(1)
+		}
+	}
+	if conn.mmProfile == "" {
+		conn.mmProfile = getEnvWithDefault("MM_PROFILE", "").(string)
+	}
+	if err := applyProfile(conn, commandLine, conn.mmProfile != ""); err != nil {
+		LogMessage(errorLevel, "Unable to load server profile: "+err.Error())
+		exit(1)
+	}
+	if target != nil && conn.mmTokenStdin && target.ConfigFilename == configStdin {
+		LogMessage(errorLevel, "The token and the config can't both be read from stdin")
+		exit(1)
+	}
+	if target != nil && target.TemplatesChannel == "" {
+		target.TemplatesChannel = getEnvWithDefault("MM_TEMPLATES_CHANNEL", "").(string)
+	}
+}
+
+// validateConnection checks that enough information has been supplied to connect to Mattermost, prompting
+// for the token if it's missing and we're running interactively
+func validateConnection(conn *mmConnection) bool {
+	if conn.mmUsername != "" {
+		ensurePassword(conn)
+	} else {
+		ensureToken(conn)
+	}
+
+	valid := true
+	if conn.mmURL == "" {
+		LogMessage(errorLevel, "The Mattermost URL must be supplied either on the command line of vie the MM_URL environment variable")
+		valid = false
+	} else if _, err := ServerURL(*conn); err != nil {
+		LogMessage(errorLevel, "Invalid Mattermost URL: "+err.Error())
+		valid = false
+	}
+	if conn.mmUsername != "" {
+		if conn.mmPassword == "" {
+			LogMessage(errorLevel, "A password must be supplied for -username, via -password-file, MM_PASSWORD or the prompt")
+			valid = false
+		}
+	} else if conn.mmToken == "" {
+		LogMessage(errorLevel, "The Mattermost auth token must be supplied either on the command line (-token, -token-file or -token-stdin) or via the MM_TOKEN/MM_TOKEN_FILE environment variables")
+		valid = false
+	}
+	return valid
+}
+
+// validateTarget checks that exactly one way of selecting channels has been supplied
+func validateTarget(target targetOptions) bool {
+	valid := true
+	if target.Channel == "" && target.Inventory == "" {
+		LogMessage(errorLevel, "A Mattermost Channel ID or an inventory file is required to use this utility.")
+		valid = false
+	}
+	if target.Channel != "" && target.Inventory != "" {
+		LogMessage(errorLevel, "Only one of -channel and -inventory may be supplied.")
+		valid = false
+	}
+	return valid
+}
+
+func logParameters(conn mmConnection, target targetOptions) {
+	DebugMessage := fmt.Sprintf("Parameters: \n  Profile=%s\n  MattermostURL=%s\n  MattermostPort=%s\n  MattermostScheme=%s\n  MattermostToken=%s\n  ChannelID=%s\n  JSON File=%s\n  Inventory=%s\n",
+		conn.mmProfile,
+		conn.mmURL,
+		conn.mmPort,
+		conn.mmScheme,
+		describeSecret(conn.mmToken),
+		target.Channel,
+		target.ConfigFilename,
+		target.Inventory,
+	)
+	DebugPrint(DebugMessage)
+}
+
+// ServerURL builds the base URL of the server from -url, which may be a bare hostname or a full URL including a
+// subpath.  -scheme and -port, if given, override the parts of the URL.
+func ServerURL(conn mmConnection) (string, error) {
+	raw := strings.TrimSpace(conn.mmURL)
+	if !strings.Contains(raw, "://") {
+		raw = defaultScheme + "://" + raw
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if conn.mmScheme != "" {
+		parsed.Scheme = strings.ToLower(conn.mmScheme)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme %q (expected http or https)", parsed.Scheme)
+	}
+	if parsed.Hostname() == "" {
+		return "", fmt.Errorf("no hostname in %q", conn.mmURL)
+	}
+	if parsed.User != nil || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return "", fmt.Errorf("%q should only contain the scheme, host, port and path", conn.mmURL)
+	}
+	if conn.mmPort != "" {
+		if port, err := strconv.Atoi(conn.mmPort); err != nil || port < 1 || port > 65535 {
+			return "", fmt.Errorf("invalid port %q", conn.mmPort)
+		}
+		parsed.Host = net.JoinHostPort(parsed.Hostname(), conn.mmPort)
+	}
+	parsed.Path = strings.TrimRight(parsed.Path, "/")
+	parsed.RawPath = ""
+
+	return parsed.String(), nil
+}
+
+// Connect prepares the Mattermost client.  Configs hosted in Mattermost are read over the same connection.
+func Connect(conn mmConnection, target targetOptions) *model.Client4 {
+	mmTarget, err := ServerURL(conn)
+	if err != nil {
+		LogMessage(errorLevel, "Invalid Mattermost URL: "+err.Error())
+		exit(1)
+	}
+
+	DebugPrint("Full target for Mattermost: " + mmTarget)
+	mmClient := model.NewAPIv4Client(mmTarget)
+
+	httpClient, err := NewHTTPClient(conn)
+	if err != nil {
+		LogMessage(errorLevel, "Unable to set up the connection: "+err.Error())
+		exit(1)
+	}
+	mmClient.HTTPClient = httpClient
+
+	if conn.mmUsername != "" {
+		if err := Login(mmClient, conn); err != nil {
+			LogMessage(errorLevel, "Unable to log in as "+conn.mmUsername+": "+err.Error())
+			exit(1)
+		}
+	} else {
+		mmClient.SetToken(conn.mmToken)
+	}
+	DebugPrint("Connected to Mattermost")
+
+	configClient = mmClient
+	templatesChannel = target.TemplatesChannel
+
+	return mmClient
+}
+
+// ResolveTargets returns the channels selected with -channel or -inventory, each with its own rendered config.
+// The second value is the number of inventory rows which couldn't be resolved.
+func ResolveTargets(mmClient model.Client4, target targetOptions) ([]channelTarget, int) {
+	if target.Inventory == "" {
+		channelID, err := ResolveChannelID(mmClient, target.Channel)
+		if err != nil {
+			LogMessage(errorLevel, "Unable to find channel "+target.Channel+".  Aborting.")
+			exit(2)
+		}
+		config := ProcessConfigFile(target.ConfigFilename, nil)
+		return []channelTarget{{Ref: target.Channel, ChannelID: channelID, Config: config}}, 0
+	}
+
+	return ResolveInventory(mmClient, target.Inventory, target.ConfigFilename)
+}
+
+// parseApplySteps turns a comma-separated list such as "header,bookmarks" into the steps to run
+func parseApplySteps(list string) (applySteps, error) {
+	var steps applySteps
+	for _, step := range strings.Split(list, ",") {
+		switch strings.TrimSpace(strings.ToLower(step)) {
+		case "pinned":
+			steps.Pinned = true
+		case "header":
+			steps.Header = true
+		case "bookmarks":
+			steps.Bookmarks = true
+		case "":
+		default:
+			return steps, errors.New("unknown step '" + step + "' (expected pinned, header or bookmarks)")
+		}
+	}
+	if steps == (applySteps{}) {
+		return steps, errors.New("no steps selected")
+	}
+	return steps, nil
+}
+
+// RunApply implements the 'apply' command, which is also run when no command is given
+func RunApply(args []string) {
+	var StepsList string
+	var NoHeaderFlag bool
+	var VersionFlag bool
+
+	flags := newCommandFlags("apply", "Adds the pinned post, channel header and bookmarks from the config to each channel.")
+	flags.StringVar(&StepsList, "steps", "pinned,header,bookmarks", "Comma-separated list of the steps to run")
+	flags.BoolVar(&NoHeaderFlag, "noheader", false, "Don't create a channel header - just add bookmarks")
+	flags.BoolVar(&VersionFlag, "version", false, "Show version information and exit")
+
+	steps, conn, target := parseApplyFlags(flags, args, func() (applySteps, error) {
+		steps, err := parseApplySteps(StepsList)
+		if NoHeaderFlag {
+			steps.Header = false
+		}
+		return steps, err
+	})
+
+	if VersionFlag {
+		printVersion()
+		exit(0)
+	}
+
+	runApply("apply", conn, target, steps)
+}
+
+// runApplySteps implements the 'header', 'bookmarks' and 'pinned' commands, which each run a single step
+func runApplySteps(name string, steps applySteps, args []string) {
+	flags := newCommandFlags(name, "Runs only the '"+name+"' step of 'apply'.")
+	_, conn, target := parseApplyFlags(flags, args, func() (applySteps, error) {
+		return steps, nil
+	})
+	runApply(name, conn, target, steps)
+}
+
+func parseApplyFlags(flags *flag.FlagSet, args []string, getSteps func() (applySteps, error)) (applySteps, mmConnection, targetOptions) {
+	var conn mmConnection
+	var target targetOptions
+	var EnsureMembersFlag bool
+
+	addConnectionFlags(flags, &conn)
+	addTargetFlags(flags, &target)
+	flags.BoolVar(&EnsureMembersFlag, "ensure-members", false, "Add team contacts who have an account to the channel's team and the channel, if they aren't members")
+	flags.StringVar(&snapshotDir, "snapshot-dir", "", "Directory where snapshots for 'rollback' are saved. [Default: user config directory]")
+	flags.Func("result", "Result format: text, or json to print a JSON summary of everything the run did. [Default: text]", setOutputFormat)
+
+	// Parse Command Line
+	DebugPrint("Parsing command line")
+	parseCommandFlags(flags, args)
+
+	steps, err := getSteps()
+	if err != nil {
+		LogMessage(errorLevel, "Invalid -steps: "+err.Error())
+		flags.Usage()
+		exit(1)
+	}
+	steps.Members = EnsureMembersFlag
+
+	return steps, conn, target
+}
+
+func runApply(name string, conn mmConnection, target targetOptions, steps applySteps) {
+	// If information not supplied on the command line, check whether it's available as an envrionment variable
+	resolveEnvironment(&conn, &target)
+	logParameters(conn, target)
+
+	// Validate required parameters
+	DebugPrint("Validating parameters")
+	connectionValid := validateConnection(&conn)
+	targetValid := validateTarget(target)
+	if !connectionValid || !targetValid {
+		exit(1)
+	}
+
+	if (target.ConfigFilename == configStdin || conn.mmTokenStdin) && !terminalAvailable() {
+		LogMessage(warningLevel, "The config or token is being read from stdin and there's no terminal, so interactive prompts will not be able to read a response")
+	}
+
+	mmClient := Connect(conn, target)
+	startRunResult(name)
+
+	LogMessage(infoLevel, "Processing started - Version: "+Version)
+
+	channels, failures := ResolveTargets(*mmClient, target)
+	skipped := AdaptToServer(*mmClient, &steps, channels)
+	if runResult != nil {
+		runResult.SkippedFeatures = skipped
+	}
+	for _, channel := range channels {
+		if target.Inventory != "" {
+			LogMessage(infoLevel, "Applying "+channel.Config.Source+" to channel "+channel.Ref)
+		}
+		SetLogChannel(channel.Ref, channel.ChannelID)
+		startChannelResult(channel.Ref, channel.ChannelID)
+		ProcessChannel(*mmClient, channel.ChannelID, channel.Config, steps)
+		ClearLogChannel()
+	}
+
+	logMembershipChanges()
+	if len(skipped) > 0 {
+		LogMessage(warningLevel, "Features not available on this server, and skipped: "+strings.Join(skipped, ", "))
+	}
+
+	if failures > 0 {
+		LogMessage(errorLevel, fmt.Sprintf("%d inventory rows could not be processed", failures))
+		exit(14)
+	}
+}
+
+// withPinnedPostLink returns a copy of the config with an "Additional Resources" link to the pinned post added
+// to the bookmarks and header.  The original config is left untouched, as it may be shared between channels.
+func withPinnedPostLink(config *Config, linkToPinnedPost string) *Config {
+	updated := *config
+	if len(linkToPinnedPost) == 0 {
+		return &updated
+	}
+
+	additionalResources := Bookmark{
+		DisplayName: "Additional Resources",
+		LinkURL:     linkToPinnedPost,
+		Emoji:       ":bulb:",
+	}
+	if len(config.Bookmarks) > 0 {
+		updated.Bookmarks = append(append([]Bookmark{}, config.Bookmarks...), additionalResources)
+	}
+	if len(config.HeaderLinks) > 0 {
+		updated.HeaderLinks = append(append([]Bookmark{}, config.HeaderLinks...), additionalResources)
+	}
+	return &updated
+}
+
+// ProcessChannel runs the pinned post, channel header and bookmark steps against a single channel
+func ProcessChannel(mmClient model.Client4, MattermostChannel string, config *Config, steps applySteps) {
+	snapshot, err := TakeSnapshot(mmClient, MattermostChannel)
+	if err != nil {
+		LogMessage(errorLevel, "Unable to record the current state of the channel.  Aborting.")
+		exit(51)
+	}
+
+	linkToPinnedPost, pinnedPostID := "", ""
+	if steps.Pinned {
+		linkToPinnedPost, pinnedPostID = ProcessPinnedPosts(mmClient, MattermostChannel, config, snapshot)
+	}
+
+	DebugPrint("Link to pinned post: " + linkToPinnedPost)
+
+	config = withPinnedPostLink(config, linkToPinnedPost)
+
+	if steps.Header || steps.Members {
+		config = withResolvedContacts(mmClient, config)
+	}
+
+	// Only process the channel header if we need to
+	if steps.Header {
+		ProcessChannelHeader(mmClient, MattermostChannel, config, snapshot)
+	}
+
+	if steps.Members {
+		EnsureContactMembers(mmClient, MattermostChannel, config, snapshot)
+	}
+
+	if steps.Bookmarks {
+		config = withAvailableEmoji(mmClient, config)
+		ProcessChannelBookmarks(mmClient, MattermostChannel, config, snapshot)
+	}
+
+	finishChannelResult(mmClient, snapshot, linkToPinnedPost, pinnedPostID)
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestServerURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		conn    mmConnection
+		want    string
+		wantErr bool
+	}{
+		{name: "bare hostname", conn: mmConnection{mmURL: "chat.example.com"}, want: "https://chat.example.com"},
+		{name: "hostname with port", conn: mmConnection{mmURL: "chat.example.com:8065"}, want: "https://chat.example.com:8065"},
+		{name: "full URL with subpath", conn: mmConnection{mmURL: "http://chat.example.com/mattermost/"}, want: "http://chat.example.com/mattermost"},
+		{name: "scheme and port override", conn: mmConnection{mmURL: "https://chat.example.com:8443", mmScheme: "HTTP", mmPort: "8065"}, want: "http://chat.example.com:8065"},
+		{name: "unsupported scheme", conn: mmConnection{mmURL: "ftp://chat.example.com"}, wantErr: true},
+		{name: "credentials in URL", conn: mmConnection{mmURL: "https://admin@chat.example.com"}, wantErr: true},
+		{name: "invalid port", conn: mmConnection{mmURL: "chat.example.com", mmPort: "70000"}, wantErr: true},
+		{name: "no hostname", conn: mmConnection{mmURL: ""}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ServerURL(test.conn)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ServerURL() error = %v, want error: %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("ServerURL() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -1,22 +1,60 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
 )
 
 // Struct definitions
+
+// Person is a team contact.  Only a username or email is needed; anything else missing is filled in from their
+// Mattermost profile when the header is built.
 type Person struct {
-	Role  string `json:"role"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	Role         string `json:"role,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Email        string `json:"email,omitempty"`
+	Username     string `json:"username,omitempty"`
+	WorkingHours string `json:"working_hours,omitempty"`
+
+	// UserID, Position and Timezone come from the user's profile
+	UserID   string `json:"-"`
+	Position string `json:"-"`
+	Timezone string `json:"-"`
 }
 
 type Bookmark struct {
 	DisplayName string `json:"display_name"`
 	LinkURL     string `json:"link_url"`
 	Emoji       string `json:"emoji"`
+
+	// Order is the bookmark's position in the channel, counting from 1.  Bookmarks without one keep their place.
+	Order int `json:"order,omitempty"`
+
+	// Type is "link" (the default) or "file", for a bookmark to a local file which is uploaded to the channel
+	Type string `json:"type,omitempty"`
+	Path string `json:"path,omitempty"`
+
+	// ImageURL, or a local Image which is uploaded, is shown as the icon of a link bookmark instead of the emoji
+	ImageURL string `json:"image_url,omitempty"`
+	Image    string `json:"image,omitempty"`
+}
+
+// target is where the bookmark points, for logs and comparisons
+func (b Bookmark) target() string {
+	if b.isFile() {
+		return "file:" + filepath.Base(b.Path)
+	}
+	return b.LinkURL
+}
+
+// isFile reports whether the bookmark is for an uploaded file rather than a link
+func (b Bookmark) isFile() bool {
+	return b.Type == bookmarkTypeFile
 }
 
 type Resource struct {
@@ -25,27 +63,238 @@ type Resource struct {
 	Description string `json:"description"`
 }
 
+// LinkOverride changes how a link is presented in one particular place
+type LinkOverride struct {
+	DisplayName string `json:"display_name,omitempty"`
+	Emoji       string `json:"emoji,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Link is a single URL which can appear in the channel header, as a bookmark, and/or in the pinned post
+type Link struct {
+	DisplayName string        `json:"display_name"`
+	URL         string        `json:"url"`
+	Emoji       string        `json:"emoji,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Targets     []string      `json:"targets,omitempty"`
+	Order       int           `json:"order,omitempty"`
+	Type        string        `json:"type,omitempty"`
+	Path        string        `json:"path,omitempty"`
+	ImageURL    string        `json:"image_url,omitempty"`
+	Image       string        `json:"image,omitempty"`
+	Header      *LinkOverride `json:"header,omitempty"`
+	Bookmark    *LinkOverride `json:"bookmark,omitempty"`
+	Pinned      *LinkOverride `json:"pinned,omitempty"`
+}
+
+const (
+	linkTargetHeader   = "header"
+	linkTargetBookmark = "bookmark"
+	linkTargetPinned   = "pinned"
+)
+
+var allLinkTargets = []string{linkTargetHeader, linkTargetBookmark, linkTargetPinned}
+
+const (
+	bookmarkTypeLink = "link"
+	bookmarkTypeFile = "file"
+)
+
 type Config struct {
+	Version   int        `json:"version"`
 	Team      []Person   `json:"team"`
-	Bookmarks []Bookmark `json:"bookmarks"`
-	Resources []Resource `json:"resources"`
+	Links     []Link     `json:"links,omitempty"`
+	Bookmarks []Bookmark `json:"bookmarks,omitempty"`
+	Resources []Resource `json:"resources,omitempty"`
+
+	// ContactTemplate and WorkingHours control how each team contact is shown in the header
+	ContactTemplate string `json:"contact_template,omitempty"`
+	WorkingHours    string `json:"working_hours,omitempty"`
+
+	// DefaultEmoji replaces bookmark emoji which don't exist on the server, unless they can be uploaded from EmojiDir
+	DefaultEmoji string `json:"default_emoji,omitempty"`
+	EmojiDir     string `json:"emoji_dir,omitempty"`
+
+	// HeaderLinks is built from the config when it is loaded.  Legacy configs have no way to say what goes
+	// in the header, so all of their bookmarks are shown there.
+	HeaderLinks []Bookmark `json:"-"`
+
+	// Source and Revision record where the config was loaded from
+	Source   string `json:"-"`
+	Revision string `json:"-"`
+}
+
+// applyOverride returns the link as it should appear for a single target
+func (l Link) applyOverride(override *LinkOverride) LinkOverride {
+	resolved := LinkOverride{
+		DisplayName: l.DisplayName,
+		Emoji:       l.Emoji,
+		Description: l.Description,
+	}
+	if override == nil {
+		return resolved
+	}
+	if override.DisplayName != "" {
+		resolved.DisplayName = override.DisplayName
+	}
+	if override.Emoji != "" {
+		resolved.Emoji = override.Emoji
+	}
+	if override.Description != "" {
+		resolved.Description = override.Description
+	}
+	return resolved
 }
 
-// LoadConfig reads the JSON file
-func LoadConfig(filename string) (*Config, error) {
-	file, err := os.Open(filename)
+// ExpandLinks places each entry from the 'links' section into the header, bookmarks and pinned post lists.
+// A link without any targets appears everywhere, except for files, which can only be bookmarks.
+func ExpandLinks(config *Config) error {
+	config.HeaderLinks = nil
+	for _, bookmark := range config.Bookmarks {
+		if !bookmark.isFile() {
+			config.HeaderLinks = append(config.HeaderLinks, bookmark)
+		}
+	}
+
+	for _, link := range config.Links {
+		switch link.Type {
+		case "", bookmarkTypeLink:
+			if link.URL == "" {
+				return fmt.Errorf("link %q has no URL", link.DisplayName)
+			}
+		case bookmarkTypeFile:
+			if link.Path == "" {
+				return fmt.Errorf("file link %q has no path", link.DisplayName)
+			}
+			for _, target := range link.Targets {
+				if strings.ToLower(target) != linkTargetBookmark {
+					return fmt.Errorf("file link %q can only be a bookmark", link.DisplayName)
+				}
+			}
+			if len(link.Targets) == 0 {
+				link.Targets = []string{linkTargetBookmark}
+			}
+		default:
+			return fmt.Errorf("link %q has unknown type %q (expected %s or %s)", link.DisplayName, link.Type, bookmarkTypeLink, bookmarkTypeFile)
+		}
+
+		targets := link.Targets
+		if len(targets) == 0 {
+			targets = allLinkTargets
+		}
+
+		for _, target := range targets {
+			switch strings.ToLower(target) {
+			case linkTargetHeader:
+				resolved := link.applyOverride(link.Header)
+				config.HeaderLinks = append(config.HeaderLinks, Bookmark{
+					DisplayName: resolved.DisplayName,
+					LinkURL:     link.URL,
+					Emoji:       resolved.Emoji,
+				})
+			case linkTargetBookmark:
+				resolved := link.applyOverride(link.Bookmark)
+				config.Bookmarks = append(config.Bookmarks, Bookmark{
+					DisplayName: resolved.DisplayName,
+					LinkURL:     link.URL,
+					Emoji:       resolved.Emoji,
+					Order:       link.Order,
+					Type:        link.Type,
+					Path:        link.Path,
+					ImageURL:    link.ImageURL,
+					Image:       link.Image,
+				})
+			case linkTargetPinned:
+				resolved := link.applyOverride(link.Pinned)
+				config.Resources = append(config.Resources, Resource{
+					DisplayName: resolved.DisplayName,
+					URL:         link.URL,
+					Description: resolved.Description,
+				})
+			default:
+				return fmt.Errorf("link %q has unknown target %q (expected one of %s)", link.DisplayName, target, strings.Join(allLinkTargets, ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+// LoadConfig reads the JSON file (or URL, or stdin), rendering any template variables before it is decoded
+func LoadConfig(filename string, vars map[string]string) (*Config, error) {
+	data, revision, err := ReadConfigSource(filename)
+	if err != nil {
+		return nil, err
+	}
+	if revision != "" {
+		LogMessage(infoLevel, "Using config from "+revision)
+	}
+
+	if vars != nil {
+		data, err = RenderConfigText(filename, data, vars)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, version, err := MigrateConfigData(data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
+		return nil, err
+	}
+	if version < currentConfigVersion {
+		LogMessage(infoLevel, fmt.Sprintf("Config uses schema version %d.  Run '%s migrate -config %s' to update it.", version, os.Args[0], filename))
 	}
-	defer file.Close()
 
 	var config Config
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
+	if err := json.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to decode JSON: %w", err)
 	}
+	if err := ExpandLinks(&config); err != nil {
+		return nil, err
+	}
+	config.Source = filename
+	config.Revision = revision
 	return &config, nil
 }
 
+// RenderConfigText expands {{.variable}} references in the raw config.  Values are JSON-escaped so
+// that quotes or backslashes in a variable can't break the surrounding document.
+func RenderConfigText(name string, data []byte, vars map[string]string) ([]byte, error) {
+	escaped := make(map[string]string, len(vars))
+	for key, value := range vars {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode variable %s: %w", key, err)
+		}
+		escaped[key] = string(encoded[1 : len(encoded)-1])
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, escaped); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	return rendered.Bytes(), nil
+}
+
+// RenderString expands {{.variable}} references in a single value, such as a config filename
+func RenderString(text string, vars map[string]string) (string, error) {
+	tmpl, err := template.New("value").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, vars); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
 // ProcessTeam processes the team section if it exists
 func ProcessTeam(team []Person) {
 	if len(team) == 0 {
@@ -53,9 +302,9 @@ func ProcessTeam(team []Person) {
 		return
 	}
 	if debugMode {
-		fmt.Println("  Team Members:")
+		fmt.Fprintln(consoleOutput(), "  Team Members:")
 		for _, person := range team {
-			fmt.Printf("    - %s (%s): %s\n", person.Role, person.Name, person.Email)
+			fmt.Fprintf(consoleOutput(), "    - %s: %s\n", person.Role, formatContact(person))
 		}
 	}
 }
@@ -67,9 +316,9 @@ func ProcessBookmarks(bookmarks []Bookmark) {
 		return
 	}
 	if debugMode {
-		fmt.Println("  Bookmarks:")
+		fmt.Fprintln(consoleOutput(), "  Bookmarks:")
 		for _, bookmark := range bookmarks {
-			fmt.Printf("    - [%s](%s) %s\n", bookmark.DisplayName, bookmark.LinkURL, bookmark.Emoji)
+			fmt.Fprintf(consoleOutput(), "    - [%s](%s) %s\n", bookmark.DisplayName, bookmark.target(), bookmark.Emoji)
 		}
 	}
 }
@@ -81,30 +330,33 @@ func ProcessResources(resources []Resource) {
 		return
 	}
 	if debugMode {
-		fmt.Println("  Resources:")
+		fmt.Fprintln(consoleOutput(), "  Resources:")
 		for _, resource := range resources {
-			fmt.Printf("    - %s: %s (%s)\n", resource.DisplayName, resource.Description, resource.URL)
+			fmt.Fprintf(consoleOutput(), "    - %s: %s (%s)\n", resource.DisplayName, resource.Description, resource.URL)
 		}
 	}
 }
 
-func ProcessConfigFile(ConfigFilename string) *Config {
+func ProcessConfigFile(ConfigFilename string, vars map[string]string) *Config {
 	DebugPrint("Processing JSON")
 
 	// Load JSON data
-	config, err := LoadConfig(ConfigFilename)
+	config, err := LoadConfig(ConfigFilename, vars)
 	if err != nil {
 		errMesg := fmt.Sprintf("Error processing JSON file: %v", err)
 		LogMessage(errorLevel, errMesg)
-		os.Exit(12)
+		exit(12)
 	}
 
-	// Process each JSON section
+	ProcessConfig(config)
+	return config
+}
+
+// ProcessConfig lists each section of a loaded config in debug mode
+func ProcessConfig(config *Config) {
 	ProcessTeam(config.Team)
 	ProcessBookmarks(config.Bookmarks)
 	ProcessResources(config.Resources)
 
 	DebugPrint("JSON processed")
-
-	return config
 }
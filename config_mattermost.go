@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	mattermostConfigPrefix = "mattermost://"
+	templateSearchPages    = 5
+)
+
+// Mattermost-hosted configs are fetched with the same connection used for the rest of the run
+var (
+	configClient     *model.Client4
+	templatesChannel string
+)
+
+// isMattermostConfig reports whether the config reference points at a post or file in Mattermost
+func isMattermostConfig(ref string) bool {
+	return strings.HasPrefix(ref, mattermostConfigPrefix)
+}
+
+// isConfigFile reports whether an attachment looks like a JSON or YAML config
+func isConfigFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// formatRevision describes which version of a post was used, so that it can be recorded in the logs
+func formatRevision(post *model.Post) string {
+	edited := post.CreateAt
+	if post.EditAt > 0 {
+		edited = post.EditAt
+	}
+	return fmt.Sprintf("post %s (revision %s)", post.Id, time.UnixMilli(edited).UTC().Format(time.RFC3339))
+}
+
+// fetchMattermostConfig resolves one of:
+//
+//	mattermost://post/<post-id>     - the post's JSON/YAML attachment, or a code block in its message
+//	mattermost://file/<file-id>     - a file uploaded to Mattermost
+//	mattermost://template/<name>    - the most recent attachment called <name> in the -templates-channel
+//
+// It returns the config along with its name (used to detect YAML) and a description of the revision used.
+func fetchMattermostConfig(ref string) ([]byte, string, string, error) {
+	if configClient == nil {
+		return nil, "", "", errors.New("a Mattermost connection is required to read " + ref)
+	}
+
+	kind, id, _ := strings.Cut(strings.TrimPrefix(ref, mattermostConfigPrefix), "/")
+	if id == "" {
+		return nil, "", "", errors.New("expected mattermost://post/<id>, mattermost://file/<id> or mattermost://template/<name>")
+	}
+
+	switch kind {
+	case "post":
+		return fetchConfigFromPost(*configClient, id)
+	case "file":
+		return fetchConfigFile(*configClient, id)
+	case "template":
+		return fetchConfigTemplate(*configClient, id)
+	}
+	return nil, "", "", fmt.Errorf("unknown Mattermost config type %q", kind)
+}
+
+func fetchConfigFromPost(mmClient model.Client4, postID string) ([]byte, string, string, error) {
+	DebugPrint("Fetching config from post " + postID)
+
+	ctx := context.Background()
+	etag := ""
+
+	post, response, err := mmClient.GetPost(ctx, postID, etag)
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve config post: "+err.Error())
+		return nil, "", "", err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(errorLevel, "Function call to GetPost returned bad HTTP response")
+		return nil, "", "", errors.New("bad HTTP response")
+	}
+
+	return configFromPost(mmClient, post)
+}
+
+// configFromPost prefers a JSON/YAML attachment, and falls back to a fenced code block (or the whole message)
+func configFromPost(mmClient model.Client4, post *model.Post) ([]byte, string, string, error) {
+	revision := formatRevision(post)
+
+	if post.Metadata != nil {
+		for _, file := range post.Metadata.Files {
+			if isConfigFile(file.Name) {
+				data, name, fileRevision, err := fetchConfigFile(mmClient, file.Id)
+				if err != nil {
+					return nil, "", "", err
+				}
+				return data, name, revision + ", " + fileRevision, nil
+			}
+		}
+	}
+
+	message := strings.TrimSpace(post.Message)
+	name := "post.json"
+	if start := strings.Index(message, "```"); start >= 0 {
+		block := message[start+3:]
+		language, body, _ := strings.Cut(block, "\n")
+		if end := strings.Index(body, "```"); end >= 0 {
+			body = body[:end]
+		}
+		if language = strings.TrimSpace(strings.ToLower(language)); language == "yaml" || language == "yml" {
+			name = "post.yaml"
+		}
+		message = body
+	}
+
+	if message == "" {
+		return nil, "", "", errors.New("post " + post.Id + " does not contain a config")
+	}
+	return []byte(message), name, revision, nil
+}
+
+func fetchConfigFile(mmClient model.Client4, fileID string) ([]byte, string, string, error) {
+	DebugPrint("Fetching config from file " + fileID)
+
+	ctx := context.Background()
+
+	info, response, err := mmClient.GetFileInfo(ctx, fileID)
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve config file info: "+err.Error())
+		return nil, "", "", err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(errorLevel, "Function call to GetFileInfo returned bad HTTP response")
+		return nil, "", "", errors.New("bad HTTP response")
+	}
+
+	data, response, err := mmClient.GetFile(ctx, fileID)
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to download config file: "+err.Error())
+		return nil, "", "", err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(errorLevel, "Function call to GetFile returned bad HTTP response")
+		return nil, "", "", errors.New("bad HTTP response")
+	}
+
+	return data, info.Name, "file " + info.Id + " (" + info.Name + ")", nil
+}
+
+// fetchConfigTemplate finds the newest post in the templates channel with an attachment of the given name
+func fetchConfigTemplate(mmClient model.Client4, name string) ([]byte, string, string, error) {
+	if templatesChannel == "" {
+		return nil, "", "", errors.New("-templates-channel must be set to use mattermost://template/")
+	}
+
+	channelID, err := ResolveChannelID(mmClient, templatesChannel)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	DebugPrint("Searching templates channel for " + name)
+
+	ctx := context.Background()
+	etag := ""
+
+	for page := 0; page < templateSearchPages; page++ {
+		posts, response, err := mmClient.GetPostsForChannel(ctx, channelID, page, pageSize, etag, false, false)
+
+		if err != nil {
+			LogMessage(errorLevel, "Failed to retrieve posts from templates channel: "+err.Error())
+			return nil, "", "", err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(errorLevel, "Function call to GetPostsForChannel returned bad HTTP response")
+			return nil, "", "", errors.New("bad HTTP response")
+		}
+
+		for _, postID := range posts.Order {
+			post := posts.Posts[postID]
+			if post.Metadata == nil {
+				continue
+			}
+			for _, file := range post.Metadata.Files {
+				if file.Name == name {
+					data, fileName, fileRevision, err := fetchConfigFile(mmClient, file.Id)
+					if err != nil {
+						return nil, "", "", err
+					}
+					return data, fileName, formatRevision(post) + ", " + fileRevision, nil
+				}
+			}
+		}
+
+		if len(posts.Order) < pageSize {
+			break
+		}
+	}
+
+	return nil, "", "", fmt.Errorf("no attachment called %s found in the templates channel", name)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	configStdin        = "-"
+	configFetchTimeout = 30 * time.Second
+)
+
+// Options for reading the config, set from the command line
+var (
+	configChecksum string
+	cacheDir       string
+)
+
+// stdinConfig holds the config read from stdin, as it can only be read once but may be used for several channels
+var stdinConfig []byte
+
+// cachedConfigMeta is stored alongside each downloaded config so that later runs can make conditional requests
+type cachedConfigMeta struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Fetched      time.Time `json:"fetched"`
+}
+
+// isRemoteConfig reports whether the config reference is a URL rather than a local file
+func isRemoteConfig(ref string) bool {
+	return strings.HasPrefix(ref, "https://") || strings.HasPrefix(ref, "http://")
+}
+
+// isLocalConfig reports whether the config reference is a file on disk, which can be rewritten in place
+func isLocalConfig(ref string) bool {
+	return ref != configStdin && !isRemoteConfig(ref) && !isMattermostConfig(ref)
+}
+
+// isYAMLConfig reports whether a config should be parsed as YAML, based on its name
+func isYAMLConfig(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, ".yaml") || strings.HasSuffix(lower, ".yml")
+}
+
+// ReadConfigSource returns the raw config from a local file, stdin ("-"), an http(s) URL, or a post or file
+// in Mattermost, verifying its checksum if one has been pinned.  YAML configs are converted to JSON.  The
+// returned string describes the revision of a Mattermost-hosted config, and is empty for other sources.
+func ReadConfigSource(ref string) ([]byte, string, error) {
+	var data []byte
+	var err error
+	name := ref
+	revision := ""
+
+	switch {
+	case ref == configStdin:
+		data, err = readConfigStdin()
+	case isRemoteConfig(ref):
+		data, err = fetchConfigURL(ref)
+	case isMattermostConfig(ref):
+		data, name, revision, err = fetchMattermostConfig(ref)
+	default:
+		data, err = os.ReadFile(ref)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open file: %w", err)
+	}
+
+	if err := verifyConfigChecksum(data); err != nil {
+		return nil, "", err
+	}
+
+	if isYAMLConfig(name) {
+		data, err = yamlToJSON(data)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decode YAML: %w", err)
+		}
+	}
+	return data, revision, nil
+}
+
+// yamlToJSON converts a YAML document into the equivalent JSON, so the rest of the config handling only
+// has to deal with one format.  Mappings keep their key order, as the order of a role-keyed team matters.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var document yaml.MapSlice
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	converted, err := convertYAMLValue(document)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(converted)
+}
+
+// convertYAMLValue replaces the mappings produced by the YAML decoder with values that can be encoded as JSON,
+// keeping their key order
+func convertYAMLValue(value interface{}) (interface{}, error) {
+	switch typed := value.(type) {
+	case yaml.MapSlice:
+		converted := make(yamlObject, len(typed))
+		for i, item := range typed {
+			convertedItem, err := convertYAMLValue(item.Value)
+			if err != nil {
+				return nil, err
+			}
+			converted[i] = yaml.MapItem{Key: item.Key, Value: convertedItem}
+		}
+		return converted, nil
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			keyString, ok := key.(string)
+			if !ok {
+				keyString = fmt.Sprint(key)
+			}
+			convertedItem, err := convertYAMLValue(item)
+			if err != nil {
+				return nil, err
+			}
+			converted[keyString] = convertedItem
+		}
+		return converted, nil
+	case []interface{}:
+		converted := make([]interface{}, len(typed))
+		for i, item := range typed {
+			convertedItem, err := convertYAMLValue(item)
+			if err != nil {
+				return nil, err
+			}
+			converted[i] = convertedItem
+		}
+		return converted, nil
+	}
+	return value, nil
+}
+
+// yamlObject is a YAML mapping, encoded as a JSON object with its keys in their original order
+type yamlObject yaml.MapSlice
+
+func (o yamlObject) MarshalJSON() ([]byte, error) {
+	var output bytes.Buffer
+	output.WriteByte('{')
+	for i, item := range o {
+		key, ok := item.Key.(string)
+		if !ok {
+			key = fmt.Sprint(item.Key)
+		}
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		encodedValue, err := json.Marshal(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			output.WriteByte(',')
+		}
+		output.Write(encodedKey)
+		output.WriteByte(':')
+		output.Write(encodedValue)
+	}
+	output.WriteByte('}')
+	return output.Bytes(), nil
+}
+
+func readConfigStdin() ([]byte, error) {
+	if stdinConfig != nil {
+		return stdinConfig, nil
+	}
+	DebugPrint("Reading config from stdin")
+	stdinUsed = true
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	stdinConfig = data
+	return data, nil
+}
+
+// verifyConfigChecksum checks the config against the SHA-256 pinned with -config-sha256
+func verifyConfigChecksum(data []byte) error {
+	if configChecksum == "" {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	actual := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(actual, strings.TrimSpace(configChecksum)) {
+		return fmt.Errorf("config checksum mismatch: expected %s, got %s", configChecksum, actual)
+	}
+	DebugPrint("Config checksum verified")
+	return nil
+}
+
+// configCacheDir returns the directory used to cache downloaded configs
+func configCacheDir() (string, error) {
+	if cacheDir != "" {
+		return filepath.Join(cacheDir, "config"), nil
+	}
+	userCache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userCache, "mm-channel-header", "config"), nil
+}
+
+// fetchConfigURL downloads the config, using the cached copy when the server reports that it hasn't changed
+// (or can't be reached)
+func fetchConfigURL(url string) ([]byte, error) {
+	DebugPrint("Fetching config from " + url)
+
+	if strings.HasPrefix(url, "http://") {
+		LogMessage(warningLevel, "Config is being fetched over plain HTTP - consider pinning it with -config-sha256")
+	}
+
+	var dataFile, metaFile string
+	var meta cachedConfigMeta
+	var cached []byte
+
+	dir, err := configCacheDir()
+	if err != nil {
+		LogMessage(warningLevel, "Unable to locate cache directory - config will not be cached: "+err.Error())
+	} else {
+		key := sha256.Sum256([]byte(url))
+		name := hex.EncodeToString(key[:])
+		dataFile = filepath.Join(dir, name+".data")
+		metaFile = filepath.Join(dir, name+".meta.json")
+
+		if metaBytes, err := os.ReadFile(metaFile); err == nil {
+			if json.Unmarshal(metaBytes, &meta) == nil && meta.URL == url {
+				cached, _ = os.ReadFile(dataFile)
+			}
+		}
+	}
+
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", "mm-channel-header/"+Version)
+	if cached != nil {
+		if meta.ETag != "" {
+			request.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			request.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+
+	client := &http.Client{Timeout: configFetchTimeout}
+	response, err := client.Do(request)
+	if err != nil {
+		if cached != nil {
+			LogMessage(warningLevel, fmt.Sprintf("Unable to fetch config (%s) - using cached copy from %s", err.Error(), meta.Fetched.Format(time.RFC3339)))
+			return cached, nil
+		}
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		if cached == nil {
+			return nil, errors.New("server returned 304 Not Modified but there is no cached copy")
+		}
+		DebugPrint("Config not modified - using cached copy")
+		return cached, nil
+	case http.StatusOK:
+	default:
+		if cached != nil && response.StatusCode >= 500 {
+			LogMessage(warningLevel, fmt.Sprintf("Config server returned %s - using cached copy from %s", response.Status, meta.Fetched.Format(time.RFC3339)))
+			return cached, nil
+		}
+		return nil, fmt.Errorf("config server returned %s", response.Status)
+	}
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if dataFile != "" {
+		meta = cachedConfigMeta{
+			URL:          url,
+			ETag:         response.Header.Get("ETag"),
+			LastModified: response.Header.Get("Last-Modified"),
+			Fetched:      time.Now().UTC(),
+		}
+		if err := writeConfigCache(dataFile, metaFile, data, meta); err != nil {
+			LogMessage(warningLevel, "Unable to cache downloaded config: "+err.Error())
+		}
+	}
+
+	return data, nil
+}
+
+func writeConfigCache(dataFile string, metaFile string, data []byte, meta cachedConfigMeta) error {
+	if err := os.MkdirAll(filepath.Dir(dataFile), 0700); err != nil {
+		return err
+	}
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dataFile, data, 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(metaFile, metaBytes, 0600)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// contactUsers caches the user found for each email address or @username, so inventory runs only look each contact
+// up once.  A nil user means the contact isn't on the server, or has been deactivated.
+var contactUsers = map[string]*model.User{}
+
+// lookupContact returns the active user with this email address, or nil if there isn't one
+func lookupContact(mmClient model.Client4, email string) *model.User {
+	key := strings.ToLower(strings.TrimSpace(email))
+	return cachedContact(key, email, func() (*model.User, *model.Response, error) {
+		return mmClient.GetUserByEmail(context.Background(), key, "")
+	})
+}
+
+// lookupContactByUsername returns the active user with this username, or nil if there isn't one
+func lookupContactByUsername(mmClient model.Client4, username string) *model.User {
+	key := "@" + strings.ToLower(strings.TrimPrefix(strings.TrimSpace(username), "@"))
+	return cachedContact(key, key, func() (*model.User, *model.Response, error) {
+		return mmClient.GetUserByUsername(context.Background(), key[1:], "")
+	})
+}
+
+// cachedContact looks a contact up once, warning if they aren't an active user on the server.  Other failures,
+// such as a timeout, aren't cached, so the lookup is tried again for the next channel.
+func cachedContact(key string, contact string, fetch func() (*model.User, *model.Response, error)) *model.User {
+	if user, cached := contactUsers[key]; cached {
+		return user
+	}
+
+	DebugPrint("Looking up contact " + contact)
+	user, response, err := fetch()
+	switch {
+	case err != nil && response != nil && response.StatusCode == 404:
+		LogMessage(warningLevel, "Contact "+contact+" isn't a user on this server, so their details from the config will be used")
+		user = nil
+	case err != nil:
+		LogMessage(warningLevel, "Unable to look up contact "+contact+": "+err.Error())
+		return nil
+	case user.DeleteAt != 0:
+		LogMessage(warningLevel, "Contact "+contact+" has been deactivated, so their details from the config will be used")
+		user = nil
+	}
+
+	contactUsers[key] = user
+	return user
+}
+
+// withResolvedContacts returns a copy of the config with each team member filled in from their Mattermost profile,
+// found by username or email address, so the header can @mention them.  Details given in the config take
+// precedence over the profile.
+func withResolvedContacts(mmClient model.Client4, config *Config) *Config {
+	updated := *config
+	updated.Team = make([]Person, len(config.Team))
+	for i, person := range config.Team {
+		if person.UserID == "" {
+			var user *model.User
+			switch {
+			case person.Username != "":
+				user = lookupContactByUsername(mmClient, person.Username)
+			case person.Email != "":
+				user = lookupContact(mmClient, person.Email)
+			}
+			if user != nil {
+				person = withProfile(person, user)
+			}
+		}
+		updated.Team[i] = person
+	}
+	return &updated
+}
+
+// withProfile fills in the person's details from their user profile
+func withProfile(person Person, user *model.User) Person {
+	person.UserID = user.Id
+	person.Username = user.Username
+	person.Position = user.Position
+	person.Timezone = user.GetPreferredTimezone()
+	if person.Name == "" {
+		person.Name = user.GetFullName()
+	}
+	if person.Name == "" {
+		person.Name = user.Username
+	}
+	if person.Email == "" {
+		person.Email = user.Email
+	}
+	if person.Role == "" {
+		person.Role = person.Position
+	}
+	return person
+}
+
+// formatContact renders a team member for the header: an @mention when they're on the server (or only a username
+// was given), otherwise a mailto: link, or just their name if there's no email address
+func formatContact(person Person) string {
+	name := person.Name
+	if name == "" {
+		name = strings.TrimPrefix(person.Username, "@")
+	}
+	if name == "" {
+		name = person.Email
+	}
+
+	switch {
+	case person.UserID != "" || (person.Username != "" && person.Email == ""):
+		return name + " (@" + strings.TrimPrefix(person.Username, "@") + ")"
+	case person.Email != "":
+		return "[" + name + "](mailto:" + person.Email + ")"
+	default:
+		return name
+	}
+}
+
+// formatWorkingHours describes the person's working hours in their own timezone, e.g. "09:00-17:00 America/New_York".
+// The zone is named rather than given as a UTC offset, so the header doesn't change when the clocks do.
+func formatWorkingHours(person Person, defaultHours string) string {
+	hours := person.WorkingHours
+	if hours == "" {
+		hours = defaultHours
+	}
+	if hours == "" || person.Timezone == "" {
+		return hours
+	}
+	return hours + " " + person.Timezone
+}
+
+// BuildContactLine renders a team member's line in the header, using the config's contact_template if it has one.
+// The template can use {role}, {name}, {contact}, {username}, {email}, {position}, {timezone} and {hours}.
+func BuildContactLine(config *Config, person Person) string {
+	if config.ContactTemplate == "" {
+		return person.Role + " - " + formatContact(person)
+	}
+
+	username := ""
+	if person.Username != "" {
+		username = "@" + strings.TrimPrefix(person.Username, "@")
+	}
+	return strings.NewReplacer(
+		"{role}", person.Role,
+		"{name}", person.Name,
+		"{contact}", formatContact(person),
+		"{username}", username,
+		"{email}", person.Email,
+		"{position}", person.Position,
+		"{timezone}", person.Timezone,
+		"{hours}", formatWorkingHours(person, config.WorkingHours),
+	).Replace(config.ContactTemplate)
+}
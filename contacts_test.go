@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// TestCachedContactRetriesFailures checks that only missing and deactivated contacts are cached, so a contact whose
+// lookup failed is looked up again for the next channel
+func TestCachedContactRetriesFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     *model.User
+		status   int
+		err      error
+		wantUser bool
+		cached   bool
+	}{
+		{name: "active user", user: &model.User{Id: "user-id"}, status: 200, wantUser: true, cached: true},
+		{name: "not found", status: 404, err: errors.New("not found"), cached: true},
+		{name: "deactivated", user: &model.User{Id: "user-id", DeleteAt: 1}, status: 200, cached: true},
+		{name: "server error", status: 500, err: errors.New("internal error")},
+		{name: "forbidden", status: 403, err: errors.New("forbidden")},
+		{name: "timeout", err: errors.New("timeout")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			contactUsers = map[string]*model.User{}
+			fetches := 0
+			fetch := func() (*model.User, *model.Response, error) {
+				fetches++
+				var response *model.Response
+				if test.status != 0 {
+					response = &model.Response{StatusCode: test.status}
+				}
+				return test.user, response, test.err
+			}
+
+			for i := 0; i < 2; i++ {
+				if user := cachedContact("jo@example.com", "jo@example.com", fetch); (user != nil) != test.wantUser {
+					t.Errorf("lookup %d returned %+v, want a user: %v", i+1, user, test.wantUser)
+				}
+			}
+			wantFetches := 2
+			if test.cached {
+				wantFetches = 1
+			}
+			if fetches != wantFetches {
+				t.Errorf("fetched %d times, want %d", fetches, wantFetches)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/charmbracelet/x/term"
+)
+
+const redactedText = "[REDACTED]"
+
+// secrets holds every credential in use, so that LogMessage can make sure none of them are ever written out
+var secrets []string
+
+// registerSecret adds a value which must be redacted from all log output
+func registerSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	for _, existing := range secrets {
+		if existing == secret {
+			return
+		}
+	}
+	secrets = append(secrets, secret)
+}
+
+// redactSecrets replaces any registered secret in the message
+func redactSecrets(message string) string {
+	for _, secret := range secrets {
+		message = strings.ReplaceAll(message, secret, redactedText)
+	}
+	return message
+}
+
+// describeSecret shows whether a secret has been supplied, without revealing it
+func describeSecret(secret string) string {
+	if secret == "" {
+		return "(not set)"
+	}
+	return redactedText
+}
+
+// warnIfReadableByOthers logs a warning if a file holding credentials can be read by other users
+func warnIfReadableByOthers(filename string) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		LogMessage(warningLevel, fmt.Sprintf("%s can be read by other users (mode %s) - consider 'chmod 600'", filename, info.Mode().Perm()))
+	}
+}
+
+// readTokenFile reads a token from a file, ignoring surrounding whitespace.  Files which other users can read
+// are allowed, but a warning is logged.
+func readTokenFile(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	warnIfReadableByOthers(filename)
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", errors.New("token file is empty")
+	}
+	return token, nil
+}
+
+// stdinUsed is set once stdin has been read for -token-stdin or '-config -', so prompts can't read answers from it
+var stdinUsed bool
+
+// readTokenStdin reads a token from the first line of stdin, e.g. when piped from a password manager
+func readTokenStdin() (string, error) {
+	stdinUsed = true
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	token := strings.TrimSpace(line)
+	if token == "" {
+		return "", errors.New("no token was read from stdin")
+	}
+	return token, nil
+}
+
+// openTerminal opens the terminal the tool is running in, for reading answers when stdin is in use
+func openTerminal() (*os.File, error) {
+	if runtime.GOOS == "windows" {
+		return os.Open("CONIN$")
+	}
+	return os.Open("/dev/tty")
+}
+
+// terminalAvailable reports whether prompts can still be answered once stdin has been used
+func terminalAvailable() bool {
+	terminal, err := openTerminal()
+	if err != nil {
+		return false
+	}
+	terminal.Close()
+	return true
+}
+
+// promptInput returns where answers to prompts are read from.  That's stdin, unless it has been used for the token
+// or the config, when the terminal is read instead (as the menus do).  The caller must close it.
+func promptInput() (io.ReadCloser, error) {
+	if !stdinUsed {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return openTerminal()
+}
+
+// promptForToken asks for the token without echoing it.  It's only used when stdin is a terminal.
+func promptForToken() (string, error) {
+	fmt.Fprint(os.Stderr, "Mattermost auth token: ")
+	data, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// resolveToken finds the auth token from, in order: -token, -token-file, -token-stdin, MM_TOKEN and MM_TOKEN_FILE
+func resolveToken(conn *mmConnection) error {
+	sources := 0
+	for _, supplied := range []bool{conn.mmToken != "", conn.mmTokenFile != "", conn.mmTokenStdin} {
+		if supplied {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return errors.New("only one of -token, -token-file and -token-stdin may be supplied")
+	}
+
+	var err error
+	switch {
+	case conn.mmToken != "":
+		LogMessage(warningLevel, "Passing -token on the command line exposes it in shell history and process listings.  Consider -token-file or -token-stdin instead.")
+	case conn.mmTokenFile != "":
+		conn.mmToken, err = readTokenFile(conn.mmTokenFile)
+	case conn.mmTokenStdin:
+		conn.mmToken, err = readTokenStdin()
+	default:
+		conn.mmToken = getEnvWithDefault("MM_TOKEN", "").(string)
+		if tokenFile := getEnvWithDefault("MM_TOKEN_FILE", "").(string); conn.mmToken == "" && tokenFile != "" {
+			conn.mmToken, err = readTokenFile(tokenFile)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	registerSecret(conn.mmToken)
+	return nil
+}
+
+// ensureToken prompts for the token if none was supplied and a user is available to type it in
+func ensureToken(conn *mmConnection) {
+	if conn.mmToken != "" || !term.IsTerminal(os.Stdin.Fd()) {
+		return
+	}
+	token, err := promptForToken()
+	if err != nil {
+		LogMessage(errorLevel, "Unable to read token: "+err.Error())
+		return
+	}
+	conn.mmToken = token
+	registerSecret(token)
+}
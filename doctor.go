@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	checkPass = "PASS"
+	checkWarn = "WARN"
+	checkFail = "FAIL"
+	checkSkip = "SKIP"
+)
+
+// doctorReport collects the results of the preflight checks
+type doctorReport struct {
+	failed int
+}
+
+func (r *doctorReport) add(status string, name string, detail string) {
+	if status == checkFail {
+		r.failed++
+	}
+	line := fmt.Sprintf("[%s] %s", status, name)
+	if detail != "" {
+		line += ": " + detail
+	}
+	fmt.Println(line)
+}
+
+// requiredPermission is a permission 'apply' needs, with a description for the checklist
+type requiredPermission struct {
+	action     string
+	permission *model.Permission
+	bookmarks  bool
+}
+
+// channelPermissions lists the permissions 'apply' needs, for the type of channel
+func channelPermissions(channelType model.ChannelType) []requiredPermission {
+	if channelType == model.ChannelTypePrivate {
+		return []requiredPermission{
+			{"change the channel header", model.PermissionManagePrivateChannelProperties, false},
+			{"create posts", model.PermissionCreatePost, false},
+			{"pin posts", model.PermissionReadChannelContent, false},
+			{"add bookmarks", model.PermissionAddBookmarkPrivateChannel, true},
+			{"edit bookmarks", model.PermissionEditBookmarkPrivateChannel, true},
+			{"delete bookmarks", model.PermissionDeleteBookmarkPrivateChannel, true},
+			{"order bookmarks", model.PermissionOrderBookmarkPrivateChannel, true},
+		}
+	}
+	return []requiredPermission{
+		{"change the channel header", model.PermissionManagePublicChannelProperties, false},
+		{"create posts", model.PermissionCreatePost, false},
+		{"pin posts", model.PermissionReadChannelContent, false},
+		{"add bookmarks", model.PermissionAddBookmarkPublicChannel, true},
+		{"edit bookmarks", model.PermissionEditBookmarkPublicChannel, true},
+		{"delete bookmarks", model.PermissionDeleteBookmarkPublicChannel, true},
+		{"order bookmarks", model.PermissionOrderBookmarkPublicChannel, true},
+	}
+}
+
+// effectivePermissions gathers the permissions granted by the user's system, team and channel roles
+func effectivePermissions(mmClient model.Client4, user *model.User, channel *model.Channel) (map[string]bool, error) {
+	ctx := context.Background()
+	etag := ""
+
+	roleNames := strings.Fields(user.Roles)
+
+	teamMember, _, err := mmClient.GetTeamMember(ctx, channel.TeamId, user.Id, etag)
+	if err != nil {
+		return nil, fmt.Errorf("not a member of the channel's team")
+	}
+	roleNames = append(roleNames, strings.Fields(teamMember.Roles)...)
+
+	channelMember, _, err := mmClient.GetChannelMember(ctx, channel.Id, user.Id, etag)
+	if err != nil {
+		return nil, fmt.Errorf("not a member of the channel")
+	}
+	roleNames = append(roleNames, strings.Fields(channelMember.Roles)...)
+
+	roles, response, err := mmClient.GetRolesByNames(ctx, roleNames)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("function call to GetRolesByNames returned bad HTTP response")
+	}
+
+	permissions := make(map[string]bool)
+	for _, role := range roles {
+		for _, permission := range role.Permissions {
+			permissions[permission] = true
+		}
+	}
+	return permissions, nil
+}
+
+// RunDoctor implements the 'doctor' command, which checks everything needed for a run before anything is changed
+func RunDoctor(args []string) {
+	var conn mmConnection
+	var ChannelRef string
+
+	flags := newCommandFlags("doctor", "Checks connectivity, authentication, server version and permissions before a run.  Exits with status 55 if any check fails.")
+	addConnectionFlags(flags, &conn)
+	flags.StringVar(&ChannelRef, "channel", "", "The channel ID, or team-name/channel-name, to check permissions for")
+	parseCommandFlags(flags, args)
+
+	resolveEnvironment(&conn, nil)
+	if !validateConnection(&conn) {
+		flags.Usage()
+		exit(1)
+	}
+
+	report := &doctorReport{}
+	mmClient := Connect(conn, targetOptions{})
+	ctx := context.Background()
+	etag := ""
+
+	status, _, err := mmClient.GetPing(ctx)
+	if err != nil || status != model.StatusOk {
+		detail := "status " + status
+		if err != nil {
+			detail = err.Error()
+		}
+		report.add(checkFail, "Server reachable at "+mmClient.URL, detail)
+		exit(55)
+	}
+	report.add(checkPass, "Server reachable at "+mmClient.URL, "")
+
+	capabilities, err := DetectCapabilities(*mmClient)
+	if err == nil {
+		serverCapabilities = capabilities
+	}
+	switch {
+	case err != nil:
+		report.add(checkWarn, "Server version", "unable to read client config: "+err.Error())
+	case capabilities.Bookmarks:
+		report.add(checkPass, "Server version "+capabilities.Version, "supports channel bookmarks")
+	default:
+		report.add(checkWarn, "Server version "+capabilities.Version, "channel bookmarks not available ("+capabilities.BookmarksReason+")")
+	}
+
+	user, _, err := mmClient.GetMe(ctx, etag)
+	if err != nil {
+		report.add(checkFail, "Authentication", err.Error())
+		exit(55)
+	}
+	report.add(checkPass, "Authentication", "logged in as "+user.Username)
+
+	if ChannelRef == "" {
+		report.add(checkSkip, "Channel and permissions", "no -channel supplied")
+	} else {
+		doctorChannel(*mmClient, report, user, ChannelRef)
+	}
+
+	fmt.Println()
+	if report.failed > 0 {
+		LogMessage(errorLevel, fmt.Sprintf("%d checks failed", report.failed))
+		exit(55)
+	}
+	LogMessage(infoLevel, "All checks passed")
+}
+
+// doctorChannel checks that the channel can be changed by the user
+func doctorChannel(mmClient model.Client4, report *doctorReport, user *model.User, ref string) {
+	ctx := context.Background()
+	etag := ""
+
+	channelID, err := ResolveChannelID(mmClient, ref)
+	if err != nil {
+		report.add(checkFail, "Channel "+ref+" exists", err.Error())
+		return
+	}
+	channel, _, err := mmClient.GetChannel(ctx, channelID, etag)
+	if err != nil {
+		report.add(checkFail, "Channel "+ref+" exists", err.Error())
+		return
+	}
+	report.add(checkPass, "Channel "+ref+" exists", channel.Id)
+
+	if channel.DeleteAt != 0 {
+		report.add(checkFail, "Channel is not archived", "the channel has been archived")
+		return
+	}
+	report.add(checkPass, "Channel is not archived", "")
+
+	if channel.Type != model.ChannelTypeOpen && channel.Type != model.ChannelTypePrivate {
+		report.add(checkFail, "Channel type", "direct and group messages can't be set up")
+		return
+	}
+
+	if strings.Contains(" "+user.Roles+" ", " "+model.SystemAdminRoleId+" ") {
+		report.add(checkPass, "Permissions", "system admin")
+		return
+	}
+
+	permissions, err := effectivePermissions(mmClient, user, channel)
+	if err != nil {
+		report.add(checkFail, "Permissions", err.Error())
+		return
+	}
+
+	// Servers without bookmarks don't have the bookmark permissions, and 'apply' doesn't need them there
+	bookmarks := bookmarksAvailable(mmClient)
+
+	for _, required := range channelPermissions(channel.Type) {
+		if required.bookmarks && !bookmarks {
+			continue
+		}
+		if permissions[required.permission.Id] {
+			report.add(checkPass, "Permission to "+required.action, "")
+		} else {
+			report.add(checkFail, "Permission to "+required.action, "missing "+required.permission.Id)
+		}
+	}
+	if !bookmarks {
+		report.add(checkSkip, "Permission to manage bookmarks", "channel bookmarks not available")
+	}
+}
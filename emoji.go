@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// emojiImageExtensions are the image types looked for in the emoji directory
+var emojiImageExtensions = []string{".png", ".gif", ".jpg", ".jpeg"}
+
+// availableEmoji caches whether each emoji exists on the server, so it's only checked once per run
+var availableEmoji = map[string]bool{}
+
+// emojiName strips the colons from ":name:"
+func emojiName(emoji string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(emoji), ":"))
+}
+
+// emojiExists checks for a system emoji, or a custom emoji on the server
+func emojiExists(mmClient model.Client4, name string) bool {
+	if model.IsSystemEmojiName(name) {
+		return true
+	}
+	if exists, cached := availableEmoji[name]; cached {
+		return exists
+	}
+
+	DebugPrint("Checking for custom emoji :" + name + ":")
+	_, response, err := mmClient.GetEmojiByName(context.Background(), name)
+	switch {
+	case err == nil:
+		availableEmoji[name] = true
+	case response != nil && response.StatusCode == 404:
+		availableEmoji[name] = false
+	default:
+		// Custom emoji may be disabled, or we can't tell - leave the emoji as it is rather than lose it
+		LogMessage(warningLevel, "Unable to check for custom emoji :"+name+": "+err.Error())
+		return true
+	}
+	return availableEmoji[name]
+}
+
+// findEmojiImage looks for an image called after the emoji in the emoji directory
+func findEmojiImage(dir string, name string) string {
+	for _, extension := range emojiImageExtensions {
+		filename := filepath.Join(dir, name+extension)
+		if _, err := os.Stat(filename); err == nil {
+			return filename
+		}
+	}
+	return ""
+}
+
+// uploadEmoji creates a custom emoji from an image in the emoji directory
+func uploadEmoji(mmClient model.Client4, name string, dir string) bool {
+	filename := findEmojiImage(dir, name)
+	if filename == "" {
+		DebugPrint("No image for :" + name + ": in " + dir)
+		return false
+	}
+
+	image, err := os.ReadFile(filename)
+	if err != nil {
+		LogMessage(warningLevel, "Unable to read "+filename+": "+err.Error())
+		return false
+	}
+
+	ctx := context.Background()
+	me, _, err := mmClient.GetMe(ctx, "")
+	if err != nil {
+		LogMessage(warningLevel, "Unable to upload custom emoji :"+name+": "+err.Error())
+		return false
+	}
+
+	LogMessage(infoLevel, "Uploading custom emoji :"+name+": from "+filename)
+	_, response, err := mmClient.CreateEmoji(ctx, &model.Emoji{Name: name, CreatorId: me.Id}, image, filepath.Base(filename))
+	if err != nil {
+		LogMessage(warningLevel, "Unable to upload custom emoji :"+name+": "+err.Error())
+		return false
+	}
+	if response.StatusCode != 201 && response.StatusCode != 200 {
+		LogMessage(warningLevel, "Function call to CreateEmoji returned bad HTTP response")
+		return false
+	}
+
+	availableEmoji[name] = true
+	return true
+}
+
+// withAvailableEmoji returns a copy of the config where every bookmark's emoji exists on the server.  A missing emoji
+// is uploaded from the config's emoji_dir if it has an image for it, and otherwise replaced by default_emoji, or
+// removed.
+func withAvailableEmoji(mmClient model.Client4, config *Config) *Config {
+	updated := *config
+	updated.Bookmarks = make([]Bookmark, len(config.Bookmarks))
+
+	for i, bookmark := range config.Bookmarks {
+		name := emojiName(bookmark.Emoji)
+		if name != "" && !emojiExists(mmClient, name) {
+			switch {
+			case config.EmojiDir != "" && uploadEmoji(mmClient, name, config.EmojiDir):
+			case config.DefaultEmoji != "" && emojiExists(mmClient, emojiName(config.DefaultEmoji)):
+				LogMessage(warningLevel, "Emoji "+bookmark.Emoji+" isn't available on this server, so "+config.DefaultEmoji+" will be used for "+bookmark.DisplayName)
+				bookmark.Emoji = config.DefaultEmoji
+			default:
+				LogMessage(warningLevel, "Emoji "+bookmark.Emoji+" isn't available on this server, so "+bookmark.DisplayName+" will have no emoji")
+				bookmark.Emoji = ""
+			}
+		}
+		updated.Bookmarks[i] = bookmark
+	}
+	return &updated
+}
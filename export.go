@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Patterns matching the rows written by BuildChannelHeader and BuildPinnedPostMessage
+var (
+	headerPersonPattern  = regexp.MustCompile(`^(.+?) - \[(.+?)\]\((?:mailto:)?(.*?)\)$`)
+	headerMentionPattern = regexp.MustCompile(`^(.+?) - (.+?) \(@([a-z0-9._\-]+)\)$`)
+	headerLinkPattern    = regexp.MustCompile(`^\|\s*\[(.+?)\]\((.+?)\)\s*\|$`)
+	pinnedRowPattern     = regexp.MustCompile(`^\|\s*\[(.+?)\]\((.+?)\)\s*\|\s*(.*?)\s*\|$`)
+)
+
+const pinnedPostHeading = "## Additional Resources"
+
+// linkCollector merges the links found in the header, bookmarks and pinned post, keyed by URL
+type linkCollector struct {
+	links []*Link
+	byURL map[string]*Link
+}
+
+func (c *linkCollector) add(target string, name string, url string, emoji string, description string) {
+	key := normaliseLinkURL(url)
+	link, exists := c.byURL[key]
+	if !exists {
+		link = &Link{DisplayName: name, URL: url, Emoji: emoji, Description: description}
+		c.byURL[key] = link
+		c.links = append(c.links, link)
+	}
+	for _, existing := range link.Targets {
+		if existing == target {
+			return
+		}
+	}
+	link.Targets = append(link.Targets, target)
+
+	if link.Emoji == "" {
+		link.Emoji = emoji
+	}
+	if link.Description == "" {
+		link.Description = description
+	}
+	if name != link.DisplayName {
+		override := &LinkOverride{DisplayName: name}
+		switch target {
+		case linkTargetHeader:
+			link.Header = override
+		case linkTargetBookmark:
+			link.Bookmark = override
+		case linkTargetPinned:
+			link.Pinned = override
+		}
+	}
+}
+
+func (c *linkCollector) result() []Link {
+	links := make([]Link, 0, len(c.links))
+	for _, link := range c.links {
+		if len(link.Targets) == len(allLinkTargets) {
+			link.Targets = nil
+		}
+		links = append(links, *link)
+	}
+	return links
+}
+
+// isPinnedPostLink recognises the "Additional Resources" link, which is added by every run rather than the config.
+// It's a permalink into the channel's team, so it starts with that team's permalink prefix.
+func isPinnedPostLink(url string, permalinkPrefix string) bool {
+	return strings.HasPrefix(url, permalinkPrefix)
+}
+
+// ExportChannel builds a config from the current header, bookmarks and pinned post of a channel
+func ExportChannel(mmClient model.Client4, channelID string) (*Config, error) {
+	DebugPrint("Exporting channel " + channelID)
+
+	ctx := context.Background()
+	etag := ""
+
+	config := &Config{Version: currentConfigVersion}
+	collector := &linkCollector{byURL: map[string]*Link{}}
+
+	channel, response, err := mmClient.GetChannel(ctx, channelID, etag)
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve channel: "+err.Error())
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(errorLevel, "Function call to GetChannel returned bad HTTP response")
+		return nil, errors.New("bad HTTP response")
+	}
+
+	team, response, err := mmClient.GetTeam(ctx, channel.TeamId, etag)
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve team: "+err.Error())
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(errorLevel, "Function call to GetTeam returned bad HTTP response")
+		return nil, errors.New("bad HTTP response")
+	}
+	permalinkPrefix := PermalinkBaseURL(mmClient) + "/" + team.Name + "/pl/"
+
+	for _, line := range strings.Split(channel.Header, "\n") {
+		line = strings.TrimSpace(line)
+		if match := headerPersonPattern.FindStringSubmatch(line); match != nil {
+			config.Team = append(config.Team, Person{Role: match[1], Name: match[2], Email: match[3]})
+		} else if match := headerMentionPattern.FindStringSubmatch(line); match != nil {
+			config.Team = append(config.Team, Person{Role: match[1], Name: match[2], Username: match[3]})
+		} else if match := headerLinkPattern.FindStringSubmatch(line); match != nil && !isPinnedPostLink(match[2], permalinkPrefix) {
+			collector.add(linkTargetHeader, match[1], match[2], "", "")
+		}
+	}
+
+	if bookmarksAvailable(mmClient) {
+		bookmarks, response, err := mmClient.ListChannelBookmarksForChannel(ctx, channelID, 0)
+
+		if err != nil {
+			LogMessage(errorLevel, "Failed to retrieve bookmarks: "+err.Error())
+			return nil, err
+		}
+		if response.StatusCode != 200 && response.StatusCode != 201 {
+			LogMessage(errorLevel, "Function call to ListChannelBookmarksForChannel returned bad HTTP response")
+			return nil, errors.New("bad HTTP response")
+		}
+
+		for _, bookmark := range bookmarks {
+			if bookmark.Type != model.ChannelBookmarkLink || isPinnedPostLink(bookmark.LinkUrl, permalinkPrefix) {
+				continue
+			}
+			collector.add(linkTargetBookmark, bookmark.DisplayName, bookmark.LinkUrl, bookmark.Emoji, "")
+		}
+	}
+
+	pinnedPosts, response, err := mmClient.GetPinnedPosts(ctx, channelID, etag)
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve pinned posts: "+err.Error())
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(errorLevel, "Function call to GetPinnedPosts returned bad HTTP response")
+		return nil, errors.New("bad HTTP response")
+	}
+
+	for _, postID := range pinnedPosts.Order {
+		message := pinnedPosts.Posts[postID].Message
+		if !strings.HasPrefix(message, pinnedPostHeading) {
+			continue
+		}
+		DebugPrint("Found resources in pinned post " + postID)
+		for _, line := range strings.Split(message, "\n") {
+			if match := pinnedRowPattern.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+				collector.add(linkTargetPinned, match[1], match[2], "", match[3])
+			}
+		}
+	}
+
+	config.Links = collector.result()
+	return config, nil
+}
+
+// RunExport implements the 'export' command
+func RunExport(args []string) {
+	var conn mmConnection
+	var ChannelRef string
+	var OutputFilename string
+
+	flags := newCommandFlags("export", "Writes a channel's current header, bookmarks and pinned post as a config file.")
+	addConnectionFlags(flags, &conn)
+	flags.StringVar(&ChannelRef, "channel", "", "The channel ID, or team-name/channel-name, to export")
+	flags.StringVar(&OutputFilename, "output", "", "Write the config to this file. [Default: stdout]")
+	parseCommandFlags(flags, args)
+	if OutputFilename == "" {
+		sendLogsToStderr()
+	}
+
+	resolveEnvironment(&conn, nil)
+	connectionValid := validateConnection(&conn)
+	if ChannelRef == "" {
+		LogMessage(errorLevel, "A Mattermost Channel ID is required to use this utility.")
+		connectionValid = false
+	}
+	if !connectionValid {
+		flags.Usage()
+		exit(1)
+	}
+
+	mmClient := Connect(conn, targetOptions{})
+
+	channelID, err := ResolveChannelID(*mmClient, ChannelRef)
+	if err != nil {
+		LogMessage(errorLevel, "Unable to find channel "+ChannelRef+".  Aborting.")
+		exit(2)
+	}
+
+	config, err := ExportChannel(*mmClient, channelID)
+	if err != nil {
+		LogMessage(errorLevel, "Unable to export channel.  Aborting.")
+		exit(53)
+	}
+
+	output, err := EncodeConfig(config)
+	if err != nil {
+		LogMessage(errorLevel, "Error encoding config: "+err.Error())
+		exit(53)
+	}
+
+	if OutputFilename == "" {
+		os.Stdout.Write(output)
+		return
+	}
+	if err := os.WriteFile(OutputFilename, output, 0644); err != nil {
+		LogMessage(errorLevel, "Unable to write config: "+err.Error())
+		exit(53)
+	}
+	LogMessage(infoLevel, "Channel exported to "+OutputFilename)
+}
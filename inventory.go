@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Reserved inventory columns.  Every other column is made available to the config as a template variable.
+const (
+	inventoryChannelColumn = "channel"
+	inventoryConfigColumn  = "config"
+)
+
+// InventoryRow holds a single channel from a CSV/TSV inventory file
+type InventoryRow struct {
+	Line      int
+	Channel   string
+	Config    string
+	Variables map[string]string
+}
+
+// normaliseColumnName turns a spreadsheet heading such as "CSM Name" into a template-friendly "csm_name"
+func normaliseColumnName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_')
+	}), "_")
+}
+
+// inventoryDelimiter picks the field separator.  TSV files are recognised by extension, or by a header
+// line that contains tabs but no commas.
+func inventoryDelimiter(filename string, data []byte) rune {
+	if strings.EqualFold(filepath.Ext(filename), ".tsv") {
+		return '\t'
+	}
+	header, _, _ := strings.Cut(string(data), "\n")
+	if strings.Contains(header, "\t") && !strings.Contains(header, ",") {
+		return '\t'
+	}
+	return ','
+}
+
+// LoadInventory reads the inventory file, returning one row per channel to be processed
+func LoadInventory(filename string) ([]InventoryRow, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	reader := csv.NewReader(strings.NewReader(string(data)))
+	reader.Comma = inventoryDelimiter(filename, data)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header row: %w", err)
+	}
+
+	columns := make([]string, len(header))
+	channelColumn := -1
+	for i, name := range header {
+		columns[i] = normaliseColumnName(name)
+		if columns[i] == inventoryChannelColumn {
+			channelColumn = i
+		}
+	}
+	if channelColumn < 0 {
+		return nil, errors.New("inventory has no '" + inventoryChannelColumn + "' column")
+	}
+
+	var rows []InventoryRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read inventory: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		row := InventoryRow{
+			Line:      line,
+			Variables: make(map[string]string),
+		}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			switch columns[i] {
+			case inventoryChannelColumn:
+				row.Channel = value
+				row.Variables[inventoryChannelColumn] = value
+			case inventoryConfigColumn:
+				row.Config = value
+			case "":
+				// Unnamed column - nothing to map it to
+			default:
+				row.Variables[columns[i]] = value
+			}
+		}
+
+		if row.Channel == "" {
+			LogMessage(warningLevel, fmt.Sprintf("Inventory line %d has no channel - skipping", line))
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// InventoryConfigFilename returns the config to use for a row.  The config filename may itself contain
+// variables (e.g. "templates/{{.tier}}.json"), and a 'config' column overrides it for a single row.
+func InventoryConfigFilename(row InventoryRow, configFilename string) (string, error) {
+	if row.Config != "" {
+		return row.Config, nil
+	}
+	return RenderString(configFilename, row.Variables)
+}
+
+// ResolveInventory finds the channel for every row in the inventory, and renders each row's config on its own.
+// Rows which can't be resolved are logged and counted, rather than stopping the whole run.
+func ResolveInventory(mmClient model.Client4, inventoryFilename string, configFilename string) ([]channelTarget, int) {
+	DebugPrint("Processing inventory: " + inventoryFilename)
+
+	rows, err := LoadInventory(inventoryFilename)
+	if err != nil {
+		LogMessage(errorLevel, "Error processing inventory file: "+err.Error())
+		exit(13)
+	}
+	LogMessage(infoLevel, fmt.Sprintf("Found %d channels in inventory", len(rows)))
+
+	var targets []channelTarget
+	failures := 0
+	for _, row := range rows {
+		rowConfig, err := InventoryConfigFilename(row, configFilename)
+		if err != nil {
+			LogMessage(errorLevel, fmt.Sprintf("Inventory line %d: unable to resolve config filename: %s", row.Line, err.Error()))
+			failures++
+			continue
+		}
+
+		channelID, err := ResolveChannelID(mmClient, row.Channel)
+		if err != nil {
+			LogMessage(errorLevel, fmt.Sprintf("Inventory line %d: unable to find channel %s: %s", row.Line, row.Channel, err.Error()))
+			failures++
+			continue
+		}
+
+		DebugPrint(fmt.Sprintf("Inventory line %d: using %s for channel %s", row.Line, rowConfig, row.Channel))
+		config, err := LoadConfig(rowConfig, row.Variables)
+		if err != nil {
+			LogMessage(errorLevel, fmt.Sprintf("Inventory line %d: unable to load config %s: %s", row.Line, rowConfig, err.Error()))
+			failures++
+			continue
+		}
+		ProcessConfig(config)
+
+		targets = append(targets, channelTarget{
+			Ref:       row.Channel,
+			ChannelID: channelID,
+			Config:    config,
+		})
+	}
+
+	return targets, failures
+}
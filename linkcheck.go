@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxLinkRedirects = 10
+
+// checkedLink is a URL from the config, and what was found when it was requested
+type checkedLink struct {
+	URL       string
+	Where     []string
+	Status    int
+	FinalURL  string
+	Permanent bool
+	Err       error
+}
+
+func (l *checkedLink) dead() bool {
+	return l.Err != nil || l.Status >= 400
+}
+
+func (l *checkedLink) redirected() bool {
+	return !l.dead() && l.FinalURL != "" && l.FinalURL != l.URL
+}
+
+// hostThrottle makes sure only one request at a time is sent to each host, with a pause between them
+type hostThrottle struct {
+	delay time.Duration
+	mu    sync.Mutex
+	hosts map[string]*hostSlot
+}
+
+type hostSlot struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (t *hostThrottle) acquire(host string) func() {
+	t.mu.Lock()
+	slot, found := t.hosts[host]
+	if !found {
+		slot = &hostSlot{}
+		t.hosts[host] = slot
+	}
+	t.mu.Unlock()
+
+	slot.mu.Lock()
+	if wait := time.Until(slot.last.Add(t.delay)); wait > 0 {
+		time.Sleep(wait)
+	}
+	return func() {
+		slot.last = time.Now()
+		slot.mu.Unlock()
+	}
+}
+
+// collectConfigLinks lists the URLs in the config's links, bookmarks and resources, along with where each is used.
+// URLs containing inventory template variables can't be checked until they're rendered, so they're skipped.
+func collectConfigLinks(config *Config) []*checkedLink {
+	byURL := make(map[string]*checkedLink)
+	var links []*checkedLink
+	add := func(link string, where string) {
+		if link == "" || strings.HasPrefix(link, "mailto:") {
+			return
+		}
+		if strings.Contains(link, "{{") {
+			DebugPrint("Skipping templated URL " + link)
+			return
+		}
+		if existing, found := byURL[link]; found {
+			existing.Where = append(existing.Where, where)
+			return
+		}
+		checked := &checkedLink{URL: link, Where: []string{where}}
+		byURL[link] = checked
+		links = append(links, checked)
+	}
+
+	for _, link := range config.Links {
+		add(link.URL, fmt.Sprintf("link %q", link.DisplayName))
+		add(link.ImageURL, fmt.Sprintf("image for link %q", link.DisplayName))
+	}
+	for _, bookmark := range config.Bookmarks {
+		add(bookmark.LinkURL, fmt.Sprintf("bookmark %q", bookmark.DisplayName))
+		add(bookmark.ImageURL, fmt.Sprintf("image for bookmark %q", bookmark.DisplayName))
+	}
+	for _, resource := range config.Resources {
+		add(resource.URL, fmt.Sprintf("resource %q", resource.DisplayName))
+	}
+	return links
+}
+
+// checkLink requests the URL, following redirects.  HEAD is tried first, falling back to GET for servers which
+// return an error for HEAD.
+func checkLink(client *http.Client, throttle *hostThrottle, link *checkedLink) {
+	parsed, err := url.Parse(link.URL)
+	if err != nil {
+		link.Err = err
+		return
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		link.Err = fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+		return
+	}
+
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		permanent := true
+		request, err := http.NewRequest(method, link.URL, nil)
+		if err != nil {
+			link.Err = err
+			return
+		}
+		request.Header.Set("User-Agent", "mm-channel-header/"+Version)
+
+		client := *client
+		client.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+			if len(via) >= maxLinkRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxLinkRedirects)
+			}
+			if code := next.Response.StatusCode; code != http.StatusMovedPermanently && code != http.StatusPermanentRedirect {
+				permanent = false
+			}
+			return nil
+		}
+
+		release := throttle.acquire(parsed.Host)
+		response, err := client.Do(request)
+		release()
+
+		if err != nil {
+			link.Err = err
+			return
+		}
+		response.Body.Close()
+
+		link.Err = nil
+		link.Status = response.StatusCode
+		link.FinalURL = response.Request.URL.String()
+		link.Permanent = permanent
+		if response.StatusCode < 400 {
+			return
+		}
+		DebugPrint(fmt.Sprintf("%s %s returned %d", method, link.URL, response.StatusCode))
+	}
+}
+
+// CheckLinks requests every link, using a pool of workers
+func CheckLinks(links []*checkedLink, concurrency int, timeout time.Duration, hostDelay time.Duration) {
+	client := &http.Client{Timeout: timeout}
+	throttle := &hostThrottle{delay: hostDelay, hosts: make(map[string]*hostSlot)}
+
+	jobs := make(chan *checkedLink)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for link := range jobs {
+				checkLink(client, throttle, link)
+			}
+		}()
+	}
+	for _, link := range links {
+		jobs <- link
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// linkFields are the fields holding URLs in each entry of the links, bookmarks and resources sections
+var linkFields = map[string]map[string]bool{
+	"links":     {"url": true, "image_url": true},
+	"bookmarks": {"link_url": true, "image_url": true},
+	"resources": {"url": true},
+}
+
+// jsonLevel tracks an object or array while walking a JSON document
+type jsonLevel struct {
+	object  bool
+	wantKey bool
+	key     string
+}
+
+// isLinkField reports whether the value being read is a URL field in one of the config's link sections
+func isLinkField(levels []*jsonLevel) bool {
+	if len(levels) != 3 || !levels[0].object || levels[1].object || !levels[2].object {
+		return false
+	}
+	return linkFields[levels[0].key][levels[2].key]
+}
+
+// rewriteConfigLinks replaces each permanently redirected URL in the raw JSON config with where it now leads.  Only
+// the URL strings themselves are changed, so the schema version, layout, field order and any fields this build
+// doesn't know about are all kept as they were.  It returns the updated document and the number of fields changed.
+func rewriteConfigLinks(data []byte, links []*checkedLink) ([]byte, int, error) {
+	replacements := make(map[string]string)
+	for _, link := range links {
+		if link.redirected() && link.Permanent {
+			replacements[link.URL] = link.FinalURL
+		}
+	}
+
+	var output bytes.Buffer
+	copied := int64(0)
+	changed := 0
+	var levels []*jsonLevel
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		start := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if delim, ok := token.(json.Delim); ok && (delim == '}' || delim == ']') {
+			levels = levels[:len(levels)-1]
+			continue
+		}
+		var parent *jsonLevel
+		if len(levels) > 0 {
+			parent = levels[len(levels)-1]
+		}
+		if parent != nil && parent.object {
+			if parent.wantKey {
+				parent.key, _ = token.(string)
+				parent.wantKey = false
+				continue
+			}
+			parent.wantKey = true
+		}
+		if delim, ok := token.(json.Delim); ok {
+			levels = append(levels, &jsonLevel{object: delim == '{', wantKey: delim == '{'})
+			continue
+		}
+
+		value, ok := token.(string)
+		final, found := replacements[value]
+		if !ok || !found || !isLinkField(levels) {
+			continue
+		}
+
+		// The string's literal starts at its opening quote, after any separators and whitespace
+		end := decoder.InputOffset()
+		start += int64(bytes.IndexByte(data[start:end], '"'))
+		var literal bytes.Buffer
+		encoder := json.NewEncoder(&literal)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(final); err != nil {
+			return nil, 0, err
+		}
+		output.Write(data[copied:start])
+		output.Write(bytes.TrimSuffix(literal.Bytes(), []byte("\n")))
+		copied = end
+		changed++
+	}
+	output.Write(data[copied:])
+	return output.Bytes(), changed, nil
+}
+
+// RunCheckLinks implements the 'check-links' command, which finds dead and redirected links in a config
+func RunCheckLinks(args []string) {
+	var conn mmConnection
+	var target targetOptions
+	var Concurrency int
+	var Timeout time.Duration
+	var HostDelay time.Duration
+	var RewriteFlag bool
+	var OutputFilename string
+
+	flags := newCommandFlags("check-links", "Checks every URL in a config's links, bookmarks and resources.  Exits with status 57 if any are dead.")
+	addConnectionFlags(flags, &conn)
+	addConfigFlags(flags, &target)
+	flags.IntVar(&Concurrency, "concurrency", 8, "Number of links to check at once")
+	flags.DurationVar(&Timeout, "timeout", 15*time.Second, "Time allowed for each request, including redirects")
+	flags.DurationVar(&HostDelay, "host-delay", 500*time.Millisecond, "Pause between requests to the same host")
+	flags.BoolVar(&RewriteFlag, "rewrite", false, "Rewrite the config to use the final URL of permanently redirected links")
+	flags.StringVar(&OutputFilename, "output", "", "With -rewrite, write the config here rather than replacing the original")
+	parseCommandFlags(flags, args)
+
+	if Concurrency < 1 {
+		LogMessage(errorLevel, "-concurrency must be at least 1")
+		flags.Usage()
+		exit(1)
+	}
+
+	resolveEnvironment(&conn, &target)
+	if isMattermostConfig(target.ConfigFilename) {
+		if !validateConnection(&conn) {
+			exit(1)
+		}
+		Connect(conn, target)
+	}
+
+	data, _, err := ReadConfigSource(target.ConfigFilename)
+	if err != nil {
+		LogMessage(errorLevel, "Error reading config file: "+err.Error())
+		exit(12)
+	}
+	migrated, _, err := MigrateConfigData(data)
+	if err != nil {
+		LogMessage(errorLevel, "Error reading config file: "+err.Error())
+		exit(12)
+	}
+	var config Config
+	if err := json.Unmarshal(migrated, &config); err != nil {
+		LogMessage(errorLevel, "Error decoding config file: "+err.Error())
+		exit(12)
+	}
+
+	links := collectConfigLinks(&config)
+	LogMessage(infoLevel, fmt.Sprintf("Checking %d links in %s", len(links), target.ConfigFilename))
+	CheckLinks(links, Concurrency, Timeout, HostDelay)
+
+	sort.SliceStable(links, func(a, b int) bool { return links[a].dead() && !links[b].dead() })
+	dead, redirected := 0, 0
+	for _, link := range links {
+		where := strings.Join(link.Where, ", ")
+		switch {
+		case link.dead():
+			dead++
+			detail := fmt.Sprintf("status %d", link.Status)
+			if link.Err != nil {
+				detail = link.Err.Error()
+			}
+			fmt.Printf("[DEAD] %s (%s): %s\n", link.URL, where, detail)
+		case link.redirected():
+			redirected++
+			kind := "temporary"
+			if link.Permanent {
+				kind = "permanent"
+			}
+			fmt.Printf("[REDIRECT] %s (%s) -> %s (%s)\n", link.URL, where, link.FinalURL, kind)
+		default:
+			fmt.Printf("[OK] %s\n", link.URL)
+		}
+	}
+	fmt.Println()
+	LogMessage(infoLevel, fmt.Sprintf("%d links checked: %d dead, %d redirected", len(links), dead, redirected))
+
+	if RewriteFlag {
+		if err := writeRewrittenConfig(links, target.ConfigFilename, OutputFilename, data); err != nil {
+			LogMessage(errorLevel, "Unable to rewrite config: "+err.Error())
+			exit(58)
+		}
+	}
+
+	if dead > 0 {
+		exit(57)
+	}
+}
+
+// writeRewrittenConfig saves the config with its redirected links replaced, keeping the original as a backup when
+// it's rewritten in place
+func writeRewrittenConfig(links []*checkedLink, configFilename string, outputFilename string, original []byte) error {
+	output, changed, err := rewriteConfigLinks(original, links)
+	if err != nil {
+		return err
+	}
+	if changed == 0 {
+		LogMessage(infoLevel, "No permanently redirected links to rewrite")
+		return nil
+	}
+
+	if outputFilename == "" {
+		if !isLocalConfig(configFilename) {
+			return errors.New("a config which isn't a local file can't be rewritten in place - use -output")
+		}
+		if isYAMLConfig(configFilename) {
+			return errors.New("the config would be rewritten as JSON - use -output to choose a new file")
+		}
+		outputFilename = configFilename
+		backupFilename := configFilename + ".bak"
+		if err := os.WriteFile(backupFilename, original, 0600); err != nil {
+			return err
+		}
+		LogMessage(infoLevel, "Original config saved to "+backupFilename)
+	}
+
+	// A YAML config has already been converted to JSON, which is laid out like the sample config
+	if isYAMLConfig(configFilename) {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, output, "", "\t"); err != nil {
+			return err
+		}
+		output = append(indented.Bytes(), '\n')
+	}
+
+	if err := os.WriteFile(outputFilename, output, 0644); err != nil {
+		return err
+	}
+	LogMessage(infoLevel, fmt.Sprintf("Rewrote %d links in %s", changed, outputFilename))
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestRewriteConfigLinksKeepsDocument(t *testing.T) {
+	original := `{
+  "custom": {"url": "https://old.example.com/"},
+  "bookmarks": [
+    {"display_name": "Docs", "link_url": "https://old.example.com/", "emoji": ":book:"}
+  ],
+  "resources": [
+    {"display_name": "Docs", "url": "https://old.example.com/", "description": "https://old.example.com/"}
+  ]
+}
+`
+	want := `{
+  "custom": {"url": "https://old.example.com/"},
+  "bookmarks": [
+    {"display_name": "Docs", "link_url": "https://new.example.com/?a=1&b=2", "emoji": ":book:"}
+  ],
+  "resources": [
+    {"display_name": "Docs", "url": "https://new.example.com/?a=1&b=2", "description": "https://old.example.com/"}
+  ]
+}
+`
+	links := []*checkedLink{{URL: "https://old.example.com/", Status: 200, FinalURL: "https://new.example.com/?a=1&b=2", Permanent: true}}
+
+	rewritten, changed, err := rewriteConfigLinks([]byte(original), links)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rewritten) != want {
+		t.Errorf("rewritten config:\n%s\nwant:\n%s", rewritten, want)
+	}
+	if changed != 2 {
+		t.Errorf("changed = %d, want 2", changed)
+	}
+}
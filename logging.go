@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var (
+	logger     *slog.Logger
+	logFormat  = logFormatText
+	logFile    string
+	logRotator *lumberjack.Logger
+	logChannel []any
+
+	// logsToStderr is set by commands which write their data to stdout, so the data can be redirected cleanly
+	logsToStderr bool
+)
+
+// slogLevels maps our log levels onto slog's
+var slogLevels = map[LogLevel]slog.Level{
+	debugLevel:   slog.LevelDebug,
+	infoLevel:    slog.LevelInfo,
+	warningLevel: slog.LevelWarn,
+	errorLevel:   slog.LevelError,
+}
+
+// debugLeveler enables debug entries whenever debug mode is on, however late -debug is parsed
+type debugLeveler struct{}
+
+func (debugLeveler) Level() slog.Level {
+	if debugMode {
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
+
+// textHandler writes log records in the original "date time [LEVEL] message" layout, followed by any fields.
+// Without a log file, errors go to stderr and everything else to stdout.
+type textHandler struct {
+	mu     *sync.Mutex
+	stdout io.Writer
+	stderr io.Writer
+	attrs  []slog.Attr
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= debugLeveler{}.Level()
+}
+
+func (h *textHandler) Handle(_ context.Context, record slog.Record) error {
+	level := strings.ToUpper(record.Level.String())
+	if record.Level == slog.LevelWarn {
+		level = string(warningLevel)
+	}
+
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s [%s] %s", record.Time.Format("2006/01/02 15:04:05"), level, record.Message)
+	writeAttr := func(attr slog.Attr) bool {
+		fmt.Fprintf(&line, " %s=%s", attr.Key, attr.Value.String())
+		return true
+	}
+	for _, attr := range h.attrs {
+		writeAttr(attr)
+	}
+	record.Attrs(writeAttr)
+	line.WriteString("\n")
+
+	output := h.stdout
+	if record.Level >= slog.LevelError {
+		output = h.stderr
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(output, line.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	updated := *h
+	updated.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &updated
+}
+
+func (h *textHandler) WithGroup(_ string) slog.Handler {
+	return h
+}
+
+// configureLogging builds the logger from the current format and log file settings.  It's called once the command
+// line has been parsed, and the log file is only reopened if it has changed.
+func configureLogging() error {
+	var stdout, stderr io.Writer = os.Stdout, os.Stderr
+	if outputFormat == outputJSON || logsToStderr {
+		stdout = os.Stderr
+	}
+	if logRotator != nil && logRotator.Filename != logFile {
+		logRotator.Close()
+		logRotator = nil
+	}
+	if logFile != "" {
+		if logRotator == nil {
+			logRotator = &lumberjack.Logger{
+				Filename:   logFile,
+				MaxSize:    10, // megabytes
+				MaxBackups: 5,
+				MaxAge:     28, // days
+			}
+		}
+		stdout, stderr = logRotator, logRotator
+	}
+
+	switch logFormat {
+	case logFormatText:
+		logger = slog.New(&textHandler{mu: &sync.Mutex{}, stdout: stdout, stderr: stderr})
+	case logFormatJSON:
+		logger = slog.New(slog.NewJSONHandler(stdout, &slog.HandlerOptions{Level: debugLeveler{}}))
+	default:
+		return fmt.Errorf("unknown log format %q (expected %s or %s)", logFormat, logFormatText, logFormatJSON)
+	}
+	return nil
+}
+
+// setLogFormat is called as -log-format is parsed.  The logger itself is built once all the flags are parsed.
+func setLogFormat(value string) error {
+	value = strings.ToLower(value)
+	if value != logFormatText && value != logFormatJSON {
+		return fmt.Errorf("unknown log format %q (expected %s or %s)", value, logFormatText, logFormatJSON)
+	}
+	logFormat = value
+	return nil
+}
+
+// sendLogsToStderr moves all logs to stderr, for a command about to write its data (e.g. an exported config) to
+// stdout
+func sendLogsToStderr() {
+	logsToStderr = true
+	configureLogging()
+}
+
+// resolveLoggingEnvironment reads MM_DEBUG, MM_LOG_FORMAT and MM_LOG_FILE.  It's called before the command line
+// is parsed, so that flags take precedence.
+func resolveLoggingEnvironment() {
+	if value := getEnvWithDefault("MM_DEBUG", "").(string); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			defer LogMessage(warningLevel, fmt.Sprintf("Ignoring MM_DEBUG=%q, which isn't true or false", value))
+		}
+		debugMode = enabled
+	}
+	if err := setLogFormat(getEnvWithDefault("MM_LOG_FORMAT", logFormatText).(string)); err != nil {
+		defer LogMessage(warningLevel, "Ignoring MM_LOG_FORMAT: "+err.Error())
+	}
+	logFile = getEnvWithDefault("MM_LOG_FILE", "").(string)
+}
+
+// SetLogChannel adds the channel to every log entry until ClearLogChannel is called, so that the entries for each
+// channel in an inventory run can be told apart
+func SetLogChannel(ref string, channelID string) {
+	logChannel = []any{slog.String("channel", ref), slog.String("channel_id", channelID)}
+}
+
+// ClearLogChannel stops adding the channel to log entries
+func ClearLogChannel() {
+	logChannel = nil
+}
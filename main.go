@@ -1,12 +1,11 @@
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
-	"log"
+	"io"
 	"os"
-
-	"github.com/mattermost/mattermost/server/public/model"
+	"strings"
 )
 
 var Version = "development" // Default value - overwritten during bild process
@@ -17,10 +16,25 @@ var debugMode bool = false
 type LogLevel string
 
 type mmConnection struct {
-	mmURL    string
-	mmPort   string
-	mmScheme string
-	mmToken  string
+	mmURL        string
+	mmPort       string
+	mmScheme     string
+	mmToken      string
+	mmTokenFile  string
+	mmTokenStdin bool
+	mmProfile    string
+
+	// Used instead of a token to log in with a username and password
+	mmUsername     string
+	mmPassword     string
+	mmPasswordFile string
+	mmMFACode      string
+
+	// TLS options for servers using an internal CA or mutual TLS
+	caCertFile         string
+	clientCertFile     string
+	clientKeyFile      string
+	insecureSkipVerify bool
 }
 
 const (
@@ -31,7 +45,6 @@ const (
 )
 
 const (
-	defaultPort       = "443"
 	defaultScheme     = "https"
 	pageSize          = 60
 	maxErrors         = 3
@@ -47,15 +60,15 @@ type PostSummary struct {
 
 // Logging functions
 
-// LogMessage logs a formatted message to stdout or stderr
+// LogMessage logs a message using the format and destination selected with -log-format and -log-file.  Any
+// registered secrets are redacted first.
 func LogMessage(level LogLevel, message string) {
-	if level == errorLevel {
-		log.SetOutput(os.Stderr)
-	} else {
-		log.SetOutput(os.Stdout)
+	if logger == nil {
+		configureLogging()
 	}
-	log.SetFlags(log.Ldate | log.Ltime)
-	log.Printf("[%s] %s\n", level, message)
+	message = redactSecrets(message)
+	logger.Log(context.Background(), slogLevels[level], message, logChannel...)
+	recordLogMessage(level, message)
 }
 
 // DebugPrint allows us to add debug messages into our code, which are only printed if we're running in debug more.
@@ -75,137 +88,76 @@ func getEnvWithDefault(key string, defaultValue interface{}) interface{} {
 	return value
 }
 
-func main() {
+// command is one of the subcommands supported by the utility
+type command struct {
+	name        string
+	description string
+	run         func(args []string)
+}
 
-	// Parse Command Line
-	DebugPrint("Parsing command line")
-
-	var MattermostURL string
-	var MattermostPort string
-	var MattermostScheme string
-	var MattermostToken string
-	var MattermostChannel string
-	var ConfigFilename string
-	var NoHeaderFlag bool
-	var DebugFlag bool
-	var VersionFlag bool
-
-	flag.StringVar(&MattermostURL, "url", "", "The URL of the Mattermost instance (without the HTTP scheme)")
-	flag.StringVar(&MattermostPort, "port", "", "The TCP port used by Mattermost. [Default: "+defaultPort+"]")
-	flag.StringVar(&MattermostScheme, "scheme", "", "The HTTP scheme to be used (http/https). [Default: "+defaultScheme+"]")
-	flag.StringVar(&MattermostToken, "token", "", "The auth token used to connect to Mattermost")
-	flag.StringVar(&MattermostChannel, "channel", "", "The channel ID to target. (Available from 'Channel Info' screen)")
-	flag.StringVar(&ConfigFilename, "config", conf_file_default, "Alternative JSON filename. [Default: "+conf_file_default+"]")
-	flag.BoolVar(&NoHeaderFlag, "noheader", false, "Don't create a channel header - just add bookmarks")
-	flag.BoolVar(&DebugFlag, "debug", debugMode, "Enable debug output")
-	flag.BoolVar(&VersionFlag, "version", false, "Show version information and exit")
-
-	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options]\n", os.Args[0])
-		fmt.Fprintln(flag.CommandLine.Output(), "Utility to quickly add predefined structures to customer channels in Mattermost.")
-		fmt.Fprintln(flag.CommandLine.Output(), "Options:")
-		flag.PrintDefaults()
+func commands() []command {
+	return []command{
+		{"apply", "Add the pinned post, channel header and bookmarks (the default)", RunApply},
+		{"header", "Update only the channel header", func(args []string) { runApplySteps("header", applySteps{Header: true}, args) }},
+		{"bookmarks", "Update only the channel bookmarks", func(args []string) { runApplySteps("bookmarks", applySteps{Bookmarks: true}, args) }},
+		{"pinned", "Add only the pinned post", func(args []string) { runApplySteps("pinned", applySteps{Pinned: true}, args) }},
+		{"plan", "Show what 'apply' would change, without changing anything", RunPlan},
+		{"audit", "Report channels which no longer match the config", RunAudit},
+		{"export", "Write a channel's current header, bookmarks and pinned post as a config", RunExport},
+		{"validate", "Check a config file for problems", RunValidate},
+		{"check-links", "Find dead and redirected links in a config", RunCheckLinks},
+		{"find-url", "Find a URL in channel headers, bookmarks and pinned posts, and optionally rewrite it", RunFindURL},
+		{"doctor", "Check connectivity, authentication, server version and permissions before a run", RunDoctor},
+		{"rollback", "Undo the most recent 'apply' to a channel", RunRollback},
+		{"migrate", "Rewrite a config file using the current schema", RunMigrate},
+		{"create-token", "Create a personal access token for a user or bot account", RunCreateToken},
+		{"version", "Show version information", func(args []string) { printVersion() }},
 	}
+}
 
-	flag.Parse()
-
-	debugMode = DebugFlag
-
-	if VersionFlag {
-		fmt.Printf("mm-channel-header - Version: %s\n\n", Version)
-		os.Exit(0)
-	}
+func printVersion() {
+	fmt.Printf("mm-channel-header - Version: %s\n\n", Version)
+}
 
-	// If information not supplied on the command line, check whether it's available as an envrionment variable
-	if MattermostURL == "" {
-		MattermostURL = getEnvWithDefault("MM_URL", "").(string)
-	}
-	if MattermostPort == "" {
-		MattermostPort = getEnvWithDefault("MM_PORT", defaultPort).(string)
-	}
-	if MattermostScheme == "" {
-		MattermostScheme = getEnvWithDefault("MM_SCHEME", defaultScheme).(string)
-	}
-	if MattermostToken == "" {
-		MattermostToken = getEnvWithDefault("MM_TOKEN", "").(string)
-	}
-	if !DebugFlag {
-		DebugFlag = getEnvWithDefault("MM_DEBUG", debugMode).(bool)
+func printCommands(output io.Writer) {
+	fmt.Fprintf(output, "Usage: %s [command] [options]\n", os.Args[0])
+	fmt.Fprintln(output, "Utility to quickly add predefined structures to customer channels in Mattermost.")
+	fmt.Fprintln(output, "")
+	fmt.Fprintln(output, "Commands:")
+	for _, cmd := range commands() {
+		fmt.Fprintf(output, "  %-12s %s\n", cmd.name, cmd.description)
 	}
+	fmt.Fprintln(output, "")
+	fmt.Fprintf(output, "Run '%s <command> -h' for the options supported by each command.\n", os.Args[0])
+}
 
-	DebugMessage := fmt.Sprintf("Parameters: \n  MattermostURL=%s\n  MattermostPort=%s\n  MattermostScheme=%s\n  MattermostToken=%s\n  ChannelID=%s\n  JSON File=%s\n",
-		MattermostURL,
-		MattermostPort,
-		MattermostScheme,
-		MattermostToken,
-		MattermostChannel,
-		ConfigFilename,
-	)
-	DebugPrint(DebugMessage)
-
-	// Validate required parameters
-	DebugPrint("Validating parameters")
-	var cliErrors bool = false
-	if MattermostURL == "" {
-		LogMessage(errorLevel, "The Mattermost URL must be supplied either on the command line of vie the MM_URL environment variable")
-		cliErrors = true
-	}
-	if MattermostScheme == "" {
-		LogMessage(errorLevel, "The Mattermost HTTP scheme must be supplied either on the command line of vie the MM_SCHEME environment variable")
-		cliErrors = true
-	}
-	if MattermostToken == "" {
-		LogMessage(errorLevel, "The Mattermost auth token must be supplied either on the command line of vie the MM_TOKEN environment variable")
-		cliErrors = true
-	}
-	if MattermostChannel == "" {
-		LogMessage(errorLevel, "A Mattermost Channel ID is required to use this utility.")
-		cliErrors = true
-	}
+func main() {
+	resolveLoggingEnvironment()
 
-	if cliErrors {
-		flag.Usage()
-		os.Exit(1)
+	// Without a command we behave exactly as earlier versions did, and run 'apply'
+	name := "apply"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name = args[0]
+		args = args[1:]
 	}
 
-	// Prepare the Mattermost connection
-	mattermostConenction := mmConnection{
-		mmURL:    MattermostURL,
-		mmPort:   MattermostPort,
-		mmScheme: MattermostScheme,
-		mmToken:  MattermostToken,
+	switch name {
+	case "help":
+		printCommands(os.Stdout)
+		return
 	}
 
-	mmTarget := fmt.Sprintf("%s://%s:%s", mattermostConenction.mmScheme, mattermostConenction.mmURL, mattermostConenction.mmPort)
-
-	DebugPrint("Full target for Mattermost: " + mmTarget)
-	mmClient := model.NewAPIv4Client(mmTarget)
-	mmClient.SetToken(mattermostConenction.mmToken)
-	DebugPrint("Connected to Mattermost")
-
-	LogMessage(infoLevel, "Processing started - Version: "+Version)
-
-	config := ProcessConfigFile(ConfigFilename)
-
-	linkToPinnedPost := ProcessPinnedPosts(*mmClient, MattermostChannel, config)
-
-	DebugPrint("Link to pinned post: " + linkToPinnedPost)
-
-	if len(linkToPinnedPost) > 0 {
-		if len(config.Bookmarks) > 0 {
-			config.Bookmarks = append(config.Bookmarks, Bookmark{
-				DisplayName: "Additional Resources",
-				LinkURL:     linkToPinnedPost,
-				Emoji:       ":bulb:",
-			})
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			cmd.run(args)
+			EndSession()
+			writeRunResult(0)
+			return
 		}
 	}
 
-	// Only process the channel header if we need to
-	if !NoHeaderFlag {
-		ProcessChannelHeader(*mmClient, MattermostChannel, config)
-	}
-
-	ProcessChannelBookmarks(*mmClient, MattermostChannel, config)
-
+	LogMessage(errorLevel, "Unknown command: "+name)
+	printCommands(os.Stderr)
+	exit(1)
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	addedToTeam    = "team"
+	addedToChannel = "channel"
+)
+
+// MembershipChange records a team contact being added to the channel's team or to the channel itself
+type MembershipChange struct {
+	Username string `json:"username"`
+	UserID   string `json:"user_id"`
+	AddedTo  string `json:"added_to"`
+	ID       string `json:"id"`
+}
+
+// membershipChanges collects every change made during the run, for the summary at the end
+var membershipChanges []string
+
+// isMember checks for a team or channel membership, treating a 404 as "not a member"
+func isMember(response *model.Response, err error) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	if response != nil && response.StatusCode == 404 {
+		return false, nil
+	}
+	return false, err
+}
+
+// EnsureContactMembers adds each team contact who has an account on the server to the channel's team and to the
+// channel, if they aren't already a member, so that everyone listed in the header can see the channel
+func EnsureContactMembers(mmClient model.Client4, MattermostChannel string, config *Config, snapshot *ChannelSnapshot) {
+	DebugPrint("Checking team contacts are members of the channel")
+
+	ctx := context.Background()
+	etag := ""
+
+	channel, response, err := mmClient.GetChannel(ctx, MattermostChannel, etag)
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve channel: "+err.Error())
+		exit(56)
+	}
+	if response.StatusCode != 200 {
+		LogMessage(errorLevel, "Function call to GetChannel returned bad HTTP response")
+		exit(56)
+	}
+	if channel.TeamId == "" {
+		LogMessage(warningLevel, "Channel isn't part of a team, so team contacts won't be added to it")
+		return
+	}
+
+	for _, person := range config.Team {
+		if person.UserID == "" {
+			continue
+		}
+
+		_, response, err := mmClient.GetTeamMember(ctx, channel.TeamId, person.UserID, etag)
+		member, err := isMember(response, err)
+		if err != nil {
+			LogMessage(errorLevel, "Unable to check team membership for @"+person.Username+": "+err.Error())
+			exit(56)
+		}
+		if !member {
+			LogMessage(infoLevel, "Adding @"+person.Username+" to the channel's team")
+			if _, _, err := mmClient.AddTeamMember(ctx, channel.TeamId, person.UserID); err != nil {
+				LogMessage(errorLevel, "Failed to add @"+person.Username+" to the team: "+err.Error())
+				exit(56)
+			}
+			recordMembershipChange(snapshot, person, addedToTeam, channel.TeamId)
+		}
+
+		_, response, err = mmClient.GetChannelMember(ctx, MattermostChannel, person.UserID, etag)
+		member, err = isMember(response, err)
+		if err != nil {
+			LogMessage(errorLevel, "Unable to check channel membership for @"+person.Username+": "+err.Error())
+			exit(56)
+		}
+		if !member {
+			LogMessage(infoLevel, "Adding @"+person.Username+" to the channel")
+			if _, _, err := mmClient.AddChannelMember(ctx, MattermostChannel, person.UserID); err != nil {
+				LogMessage(errorLevel, "Failed to add @"+person.Username+" to the channel: "+err.Error())
+				exit(56)
+			}
+			recordMembershipChange(snapshot, person, addedToChannel, MattermostChannel)
+		}
+	}
+}
+
+// recordMembershipChange keeps the change in the snapshot and the run summary.  Rollback leaves memberships alone.
+func recordMembershipChange(snapshot *ChannelSnapshot, person Person, addedTo string, id string) {
+	snapshot.AddedMembers = append(snapshot.AddedMembers, MembershipChange{
+		Username: person.Username,
+		UserID:   person.UserID,
+		AddedTo:  addedTo,
+		ID:       id,
+	})
+	snapshot.Save()
+	membershipChanges = append(membershipChanges, fmt.Sprintf("@%s added to %s %s", person.Username, addedTo, id))
+}
+
+// logMembershipChanges summarises the membership changes made during the run
+func logMembershipChanges() {
+	if len(membershipChanges) == 0 {
+		return
+	}
+	LogMessage(infoLevel, fmt.Sprintf("%d membership changes: %s", len(membershipChanges), strings.Join(membershipChanges, ", ")))
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// currentConfigVersion is the schema version written by this build.  Configs without a 'version' field are
+// treated as version 1.
+const currentConfigVersion = 2
+
+// configMigration upgrades a raw config document from one schema version to the next.  Any future format
+// change should add a new entry here, so that both loading and the 'migrate' command pick it up.  The original
+// document is passed alongside, for migrations which depend on the order of an object's keys.
+type configMigration struct {
+	from        int
+	description string
+	migrate     func(raw map[string]interface{}, data []byte) error
+}
+
+var configMigrations = []configMigration{
+	{
+		from:        1,
+		description: "convert role-keyed team object to a list, and move bookmarks/resources into links",
+		migrate:     migrateV1ToV2,
+	},
+}
+
+// configVersion returns the schema version of a raw config document
+func configVersion(raw map[string]interface{}) (int, error) {
+	value, exists := raw["version"]
+	if !exists {
+		return 1, nil
+	}
+	version, ok := value.(float64)
+	if !ok || version != float64(int(version)) || version < 1 {
+		return 0, fmt.Errorf("invalid config version: %v", value)
+	}
+	return int(version), nil
+}
+
+// MigrateConfigData upgrades a JSON config to the current schema, returning the upgraded JSON along with
+// the version it started at.
+func MigrateConfigData(data []byte) ([]byte, int, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+
+	original, err := configVersion(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	if original > currentConfigVersion {
+		return nil, original, fmt.Errorf("config version %d is newer than this tool supports (%d) - please upgrade", original, currentConfigVersion)
+	}
+	if original == currentConfigVersion {
+		return data, original, nil
+	}
+
+	version := original
+	for _, migration := range configMigrations {
+		if migration.from != version {
+			continue
+		}
+		DebugPrint(fmt.Sprintf("Migrating config from version %d: %s", version, migration.description))
+		if err := migration.migrate(raw, data); err != nil {
+			return nil, original, fmt.Errorf("failed to migrate config from version %d: %w", version, err)
+		}
+		version++
+		raw["version"] = version
+	}
+	if version != currentConfigVersion {
+		return nil, original, fmt.Errorf("no migration path from config version %d", version)
+	}
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return nil, original, fmt.Errorf("failed to encode migrated config: %w", err)
+	}
+	return migrated, original, nil
+}
+
+// objectKeys returns the keys of the object held in a top-level field of the document, in the order they appear.
+// Repeated keys are only listed once, as json.Unmarshal keeps just the last of them.
+func objectKeys(data []byte, field string) ([]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		if name, _ := token.(string); name != field {
+			continue
+		}
+
+		keys = nil
+		seen := make(map[string]bool)
+		inner := json.NewDecoder(bytes.NewReader(value))
+		if delim, err := inner.Token(); err != nil || delim != json.Delim('{') {
+			continue
+		}
+		for inner.More() {
+			token, err := inner.Token()
+			if err != nil {
+				return nil, err
+			}
+			var skipped json.RawMessage
+			if err := inner.Decode(&skipped); err != nil {
+				return nil, err
+			}
+			if key, _ := token.(string); !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// migrateV1ToV2 handles the two shapes that were in use before the schema was versioned.  The 'team' section
+// may be an object keyed by role, and links are split between 'bookmarks' (also shown in the header) and
+// 'resources' (the pinned post).
+func migrateV1ToV2(raw map[string]interface{}, data []byte) error {
+	if team, ok := raw["team"].(map[string]interface{}); ok {
+		// The people are listed in the order the roles appear in the file
+		roles, err := objectKeys(data, "team")
+		if err != nil {
+			return err
+		}
+
+		people := []interface{}{}
+		for _, role := range roles {
+			details, ok := team[role].(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("team entry %q is not an object", role)
+			}
+			person := map[string]interface{}{"role": role}
+			for key, value := range details {
+				person[key] = value
+			}
+			people = append(people, person)
+		}
+		raw["team"] = people
+	}
+
+	bookmarks, _ := raw["bookmarks"].([]interface{})
+	resources, _ := raw["resources"].([]interface{})
+	if len(bookmarks) == 0 && len(resources) == 0 {
+		delete(raw, "bookmarks")
+		delete(raw, "resources")
+		return nil
+	}
+
+	// Bookmarks and resources are kept as separate links, even when they share a URL, so that the header, bookmarks
+	// and pinned post each keep the order, names and URLs they had before
+	links, _ := raw["links"].([]interface{})
+
+	for _, entry := range bookmarks {
+		bookmark, ok := entry.(map[string]interface{})
+		if !ok {
+			return errors.New("bookmark entry is not an object")
+		}
+		link := map[string]interface{}{
+			"display_name": bookmark["display_name"],
+			"url":          bookmark["link_url"],
+			"targets":      []interface{}{linkTargetHeader, linkTargetBookmark},
+		}
+		if emoji, ok := bookmark["emoji"].(string); ok && emoji != "" {
+			link["emoji"] = emoji
+		}
+		links = append(links, link)
+	}
+
+	for _, entry := range resources {
+		resource, ok := entry.(map[string]interface{})
+		if !ok {
+			return errors.New("resource entry is not an object")
+		}
+		links = append(links, map[string]interface{}{
+			"display_name": resource["display_name"],
+			"url":          resource["url"],
+			"description":  resource["description"],
+			"targets":      []interface{}{linkTargetPinned},
+		})
+	}
+
+	raw["links"] = links
+	delete(raw, "bookmarks")
+	delete(raw, "resources")
+	return nil
+}
+
+// normaliseLinkURL allows "https://example.com" and "https://example.com/" to be recognised as the same link
+func normaliseLinkURL(url string) string {
+	return strings.TrimSuffix(strings.TrimSpace(url), "/")
+}
+
+// EncodeConfig writes the config in the same layout as the sample config.json
+func EncodeConfig(config *Config) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "\t")
+	if err := encoder.Encode(config); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// RunMigrate implements the 'migrate' command, which rewrites a config file using the current schema
+func RunMigrate(args []string) {
+	var ConfigFilename string
+	var OutputFilename string
+	var DryRunFlag bool
+
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.StringVar(&ConfigFilename, "config", conf_file_default, "The JSON config file, URL or '-' for stdin to migrate. [Default: "+conf_file_default+"]")
+	flags.StringVar(&OutputFilename, "output", "", "Write the migrated config here, rather than replacing the original")
+	flags.BoolVar(&DryRunFlag, "dry-run", false, "Print the migrated config instead of writing it")
+	flags.StringVar(&configChecksum, "config-sha256", "", "Expected SHA-256 checksum of the config")
+	flags.StringVar(&cacheDir, "cache-dir", "", "Directory used to cache configs downloaded from a URL")
+	flags.BoolVar(&debugMode, "debug", debugMode, "Enable debug output")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s migrate [options]\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "Rewrites a config file to the current schema (version %d).\n", currentConfigVersion)
+		fmt.Fprintln(flags.Output(), "Options:")
+		flags.PrintDefaults()
+	}
+	parseCommandFlags(flags, args)
+
+	// A config from a URL, stdin or Mattermost can't be rewritten in place
+	if !isLocalConfig(ConfigFilename) && OutputFilename == "" {
+		DryRunFlag = true
+	}
+	if DryRunFlag {
+		sendLogsToStderr()
+	}
+
+	data, _, err := ReadConfigSource(ConfigFilename)
+	if err != nil {
+		LogMessage(errorLevel, "Error reading config file: "+err.Error())
+		exit(12)
+	}
+
+	migrated, original, err := MigrateConfigData(data)
+	if err != nil {
+		LogMessage(errorLevel, "Error migrating config file: "+err.Error())
+		exit(15)
+	}
+
+	var config Config
+	if err := json.Unmarshal(migrated, &config); err != nil {
+		LogMessage(errorLevel, "Migrated config is not valid: "+err.Error())
+		exit(15)
+	}
+	config.Version = currentConfigVersion
+
+	output, err := EncodeConfig(&config)
+	if err != nil {
+		LogMessage(errorLevel, "Error encoding migrated config: "+err.Error())
+		exit(15)
+	}
+
+	if DryRunFlag {
+		os.Stdout.Write(output)
+		return
+	}
+
+	if original == currentConfigVersion && OutputFilename == "" {
+		LogMessage(infoLevel, fmt.Sprintf("%s is already at config version %d - nothing to do", ConfigFilename, currentConfigVersion))
+		return
+	}
+
+	if OutputFilename == "" {
+		OutputFilename = ConfigFilename
+		backupFilename := ConfigFilename + ".bak"
+		if err := os.WriteFile(backupFilename, data, 0600); err != nil {
+			LogMessage(errorLevel, "Unable to write backup of original config: "+err.Error())
+			exit(16)
+		}
+		LogMessage(infoLevel, "Original config saved to "+backupFilename)
+	}
+
+	if err := os.WriteFile(OutputFilename, output, 0644); err != nil {
+		LogMessage(errorLevel, "Unable to write migrated config: "+err.Error())
+		exit(16)
+	}
+
+	LogMessage(infoLevel, fmt.Sprintf("Migrated %s from config version %d to %d", OutputFilename, original, currentConfigVersion))
+}
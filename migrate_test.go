@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestMigrateV1ToV2SampleConfig checks that the version 1 sample renders exactly as it did before the schema was
+// versioned: bookmarks in the header and as bookmarks, and resources in the pinned post, each in their original
+// order and with their original URLs.
+func TestMigrateV1ToV2SampleConfig(t *testing.T) {
+	filename := "testdata/config_v1.json"
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var legacy Config
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(filename, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(config.Team, legacy.Team) {
+		t.Errorf("team = %+v, want %+v", config.Team, legacy.Team)
+	}
+	if !reflect.DeepEqual(config.HeaderLinks, legacy.Bookmarks) {
+		t.Errorf("header links = %+v, want %+v", config.HeaderLinks, legacy.Bookmarks)
+	}
+	if !reflect.DeepEqual(config.Bookmarks, legacy.Bookmarks) {
+		t.Errorf("bookmarks = %+v, want %+v", config.Bookmarks, legacy.Bookmarks)
+	}
+	if !reflect.DeepEqual(config.Resources, legacy.Resources) {
+		t.Errorf("resources = %+v, want %+v", config.Resources, legacy.Resources)
+	}
+}
+
+// TestMigrateV1ToV2RoleKeyedTeam checks that a team keyed by role keeps the order of the file, not of the roles
+func TestMigrateV1ToV2RoleKeyedTeam(t *testing.T) {
+	data := []byte(`{"team": {"tam": {"name": "Jo", "email": "jo@example.com"}, "csm": {"name": "Sam"}}}`)
+
+	migrated, version, err := MigrateConfigData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("version = %d, want 1", version)
+	}
+
+	var config Config
+	if err := json.Unmarshal(migrated, &config); err != nil {
+		t.Fatal(err)
+	}
+	want := []Person{{Role: "tam", Name: "Jo", Email: "jo@example.com"}, {Role: "csm", Name: "Sam"}}
+	if config.Version != currentConfigVersion || !reflect.DeepEqual(config.Team, want) {
+		t.Errorf("migrated to version %d with team %+v, want version %d with %+v", config.Version, config.Team, currentConfigVersion, want)
+	}
+}
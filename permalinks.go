@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// siteURLOverride replaces the server's SiteURL when building permalinks, set with -site-url
+var siteURLOverride string
+
+// validateSiteURL checks a -site-url override is an absolute http(s) URL
+func validateSiteURL(value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("%q must start with http:// or https://", value)
+	}
+	if parsed.Hostname() == "" {
+		return fmt.Errorf("no hostname in %q", value)
+	}
+	return nil
+}
+
+// withoutDefaultPort removes a port which is implied by the scheme, so links don't show ":443"
+func withoutDefaultPort(baseURL string) string {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return baseURL
+	}
+	if (parsed.Scheme == "https" && parsed.Port() == "443") || (parsed.Scheme == "http" && parsed.Port() == "80") {
+		parsed.Host = parsed.Hostname()
+		if strings.Contains(parsed.Host, ":") {
+			parsed.Host = "[" + parsed.Host + "]"
+		}
+	}
+	return parsed.String()
+}
+
+// PermalinkBaseURL returns the URL that users reach the server on, for building permalinks.  This is the -site-url
+// override, then the server's SiteURL, and only as a last resort the URL we connected through, which may be an
+// internal address.
+func PermalinkBaseURL(mmClient model.Client4) string {
+	if siteURLOverride != "" {
+		return strings.TrimRight(siteURLOverride, "/")
+	}
+	if siteURL := currentCapabilities(mmClient).SiteURL; siteURL != "" {
+		return siteURL
+	}
+
+	baseURL := withoutDefaultPort(mmClient.URL)
+	LogMessage(warningLevel, "The server has no SiteURL configured, so permalinks will use "+baseURL+".  Use -site-url if users reach the server on a different address.")
+	siteURLOverride = baseURL
+	return baseURL
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
 	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -154,7 +153,7 @@ func BuildLinkToPinnedPost(mmClient model.Client4, channelID string, postID stri
 
 	linkToPinnedPost := ""
 
-	baseURL := mmClient.URL
+	baseURL := PermalinkBaseURL(mmClient)
 
 	ctx := context.Background()
 	etag := ""
@@ -227,7 +226,7 @@ func GetPinnedPost(mmClient model.Client4, channelID string) (SelectionResult, e
 	menuModel := NewPaginationModel(postSummaries, menuPostPerPage)
 
 	// Run the interactive menu
-	p := tea.NewProgram(menuModel)
+	p := tea.NewProgram(menuModel, tea.WithOutput(consoleOutput()))
 	if _, err := p.Run(); err != nil {
 		LogMessage(errorLevel, "Error displaying menu: "+err.Error())
 		return SelectionResult{}, err
@@ -267,11 +266,8 @@ func truncateString(input string, maxLength int) string {
 	return input
 }
 
-func CreatePinnedPost(mmClient model.Client4, channelID string, jsonRows Config) (string, error) {
-	DebugPrint("Creating pinned post from JSON data")
-
-	ctx := context.Background()
-
+// BuildPinnedPostMessage renders the "Additional Resources" pinned post from the config
+func BuildPinnedPostMessage(jsonRows Config) string {
 	pinnedPostMessage := "## Additional Resources\n\n\n"
 	pinnedPostMessage += "| Resource                                                                                                        | Description                                                                                                     |\n"
 	pinnedPostMessage += "| --------------------------------------------------------------------------------------------------------------- | --------------------------------------------------------------------------------------------------------------- |\n"
@@ -281,6 +277,16 @@ func CreatePinnedPost(mmClient model.Client4, channelID string, jsonRows Config)
 		pinnedPostMessage += formattedRow
 	}
 
+	return pinnedPostMessage
+}
+
+func CreatePinnedPost(mmClient model.Client4, channelID string, jsonRows Config) (string, error) {
+	DebugPrint("Creating pinned post from JSON data")
+
+	ctx := context.Background()
+
+	pinnedPostMessage := BuildPinnedPostMessage(jsonRows)
+
 	postPayload := &model.Post{
 		ChannelId: channelID,
 		IsPinned:  true,
@@ -302,13 +308,14 @@ func CreatePinnedPost(mmClient model.Client4, channelID string, jsonRows Config)
 	return post.Id, nil
 }
 
-func ProcessPinnedPosts(mmClient model.Client4, MattermostChannel string, config *Config) string {
+// ProcessPinnedPosts selects or creates the pinned post, returning a link to it along with its ID
+func ProcessPinnedPosts(mmClient model.Client4, MattermostChannel string, config *Config, snapshot *ChannelSnapshot) (string, string) {
 
 	pinnedPost, err := GetPinnedPost(mmClient, MattermostChannel)
 
 	if err != nil {
 		LogMessage(errorLevel, "Interactive menu failed - aborting!")
-		os.Exit(4)
+		exit(4)
 	}
 
 	pinnedPostID := pinnedPost.PostID
@@ -321,17 +328,19 @@ func ProcessPinnedPosts(mmClient model.Client4, MattermostChannel string, config
 		pinnedPostID, err = CreatePinnedPost(mmClient, MattermostChannel, *config)
 		if err != nil {
 			LogMessage(errorLevel, "Failed to create pinned post!  "+err.Error())
-			os.Exit(6)
+			exit(6)
 		}
+		snapshot.CreatedPostID = pinnedPostID
+		snapshot.Save()
 	case "Skip":
 		LogMessage(infoLevel, "Skipping pinned post")
-		return ""
+		return "", ""
 	case "Abort":
 		LogMessage(warningLevel, "Aborting due to user selection")
-		os.Exit(0)
+		exit(0)
 	default:
 		LogMessage(errorLevel, "Interactive menu got funky!  This code should never be reached!! ( ˶°ㅁ°) !!")
-		os.Exit(3)
+		exit(3)
 	}
 
 	DebugPrint("Pinned Post ID: " + pinnedPostID)
@@ -340,8 +349,8 @@ func ProcessPinnedPosts(mmClient model.Client4, MattermostChannel string, config
 
 	if err != nil {
 		LogMessage(errorLevel, "Failed to build link to pinned post - Aborting.")
-		os.Exit(7)
+		exit(7)
 	}
 
-	return linkToPinnedPost
+	return linkToPinnedPost, pinnedPostID
 }
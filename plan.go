@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// ChannelPlan describes the difference between a channel and its config, without changing anything
+type ChannelPlan struct {
+	ChannelID        string
+	PinnedPostID     string
+	PinnedPostNeeded bool
+	CurrentHeader    string
+	ProposedHeader   string
+	HeaderChanged    bool
+	MissingBookmarks []Bookmark
+	ExtraBookmarks   []*model.ChannelBookmarkWithFileInfo
+}
+
+// HasDrift reports whether 'apply' would change anything
+func (p *ChannelPlan) HasDrift() bool {
+	return p.PinnedPostNeeded || p.HeaderChanged || len(p.MissingBookmarks) > 0 || len(p.ExtraBookmarks) > 0
+}
+
+// DriftSummary lists the parts of the channel that don't match the config
+func (p *ChannelPlan) DriftSummary() string {
+	var drift []string
+	if p.PinnedPostNeeded {
+		drift = append(drift, "pinned post missing")
+	}
+	if p.HeaderChanged {
+		drift = append(drift, "header differs")
+	}
+	if len(p.MissingBookmarks) > 0 {
+		drift = append(drift, fmt.Sprintf("%d bookmarks missing", len(p.MissingBookmarks)))
+	}
+	if len(p.ExtraBookmarks) > 0 {
+		drift = append(drift, fmt.Sprintf("%d bookmarks not in config", len(p.ExtraBookmarks)))
+	}
+	return strings.Join(drift, ", ")
+}
+
+// FindMatchingPinnedPost returns the ID of a pinned post whose message matches the one the config would create
+func FindMatchingPinnedPost(mmClient model.Client4, channelID string, config *Config) (string, error) {
+	DebugPrint("Looking for a matching pinned post")
+
+	ctx := context.Background()
+	etag := ""
+
+	pinnedPosts, response, err := mmClient.GetPinnedPosts(ctx, channelID, etag)
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve pinned posts: "+err.Error())
+		return "", err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(errorLevel, "Function call to GetPinnedPosts returned bad HTTP response")
+		return "", errors.New("bad HTTP response")
+	}
+
+	expected := strings.TrimSpace(BuildPinnedPostMessage(*config))
+	for _, postID := range pinnedPosts.Order {
+		if strings.TrimSpace(pinnedPosts.Posts[postID].Message) == expected {
+			return postID, nil
+		}
+	}
+	return "", nil
+}
+
+// PlanChannel compares the channel against what 'apply' would produce for the selected steps
+func PlanChannel(mmClient model.Client4, channelID string, config *Config, steps applySteps) (*ChannelPlan, error) {
+	DebugPrint("Planning changes for channel " + channelID)
+
+	plan := &ChannelPlan{ChannelID: channelID}
+
+	linkToPinnedPost := ""
+	if steps.Pinned && len(config.Resources) > 0 {
+		postID, err := FindMatchingPinnedPost(mmClient, channelID, config)
+		if err != nil {
+			return nil, err
+		}
+		if postID == "" {
+			plan.PinnedPostNeeded = true
+			// The real link isn't known until the post is created, but the header still needs a placeholder
+			linkToPinnedPost = "(link to new pinned post)"
+		} else {
+			plan.PinnedPostID = postID
+			linkToPinnedPost, err = BuildLinkToPinnedPost(mmClient, channelID, postID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	config = withPinnedPostLink(config, linkToPinnedPost)
+
+	ctx := context.Background()
+	etag := ""
+
+	if steps.Header {
+		config = withResolvedContacts(mmClient, config)
+		channel, response, err := mmClient.GetChannel(ctx, channelID, etag)
+
+		if err != nil {
+			LogMessage(errorLevel, "Failed to retrieve channel: "+err.Error())
+			return nil, err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(errorLevel, "Function call to GetChannel returned bad HTTP response")
+			return nil, errors.New("bad HTTP response")
+		}
+
+		plan.CurrentHeader = channel.Header
+		if len(config.HeaderLinks) > 0 {
+			plan.ProposedHeader = BuildChannelHeader(config)
+			plan.HeaderChanged = plan.CurrentHeader != plan.ProposedHeader
+		} else {
+			plan.ProposedHeader = plan.CurrentHeader
+		}
+	}
+
+	if steps.Bookmarks && len(config.Bookmarks) > 0 {
+		existing, response, err := mmClient.ListChannelBookmarksForChannel(ctx, channelID, 0)
+
+		if err != nil {
+			LogMessage(errorLevel, "Failed to retrieve bookmarks: "+err.Error())
+			return nil, err
+		}
+		if response.StatusCode != 200 && response.StatusCode != 201 {
+			LogMessage(errorLevel, "Function call to ListChannelBookmarksForChannel returned bad HTTP response")
+			return nil, errors.New("bad HTTP response")
+		}
+
+		wanted := make(map[string]bool, len(config.Bookmarks))
+		for _, bookmark := range config.Bookmarks {
+			wanted[bookmarkKey(bookmark.DisplayName, bookmark.target())] = true
+		}
+		found := make(map[string]bool, len(existing))
+		for _, bookmark := range existing {
+			key := bookmarkKey(bookmark.DisplayName, existingBookmarkTarget(bookmark))
+			found[key] = true
+			if !wanted[key] {
+				plan.ExtraBookmarks = append(plan.ExtraBookmarks, bookmark)
+			}
+		}
+		for _, bookmark := range config.Bookmarks {
+			if !found[bookmarkKey(bookmark.DisplayName, bookmark.target())] {
+				plan.MissingBookmarks = append(plan.MissingBookmarks, bookmark)
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+func bookmarkKey(displayName string, url string) string {
+	return displayName + "\x00" + url
+}
+
+// existingBookmarkTarget is where a bookmark on the server points, in the same form as Bookmark.target
+func existingBookmarkTarget(bookmark *model.ChannelBookmarkWithFileInfo) string {
+	if bookmark.Type == model.ChannelBookmarkFile && bookmark.FileInfo != nil {
+		return "file:" + bookmark.FileInfo.Name
+	}
+	return bookmark.LinkUrl
+}
+
+// PrintPlan writes a human-readable description of the plan
+func PrintPlan(ref string, plan *ChannelPlan) {
+	fmt.Printf("Channel %s (%s):\n", ref, plan.ChannelID)
+
+	switch {
+	case plan.PinnedPostNeeded:
+		fmt.Println("  Pinned post: a new pinned post will be created")
+	case plan.PinnedPostID != "":
+		fmt.Println("  Pinned post: matches existing post " + plan.PinnedPostID)
+	}
+
+	if plan.HeaderChanged {
+		fmt.Println("  Header: will change")
+		if plan.CurrentHeader != "" {
+			for _, line := range strings.Split(strings.TrimRight(plan.CurrentHeader, "\n"), "\n") {
+				fmt.Println("    - " + line)
+			}
+		}
+		for _, line := range strings.Split(strings.TrimRight(plan.ProposedHeader, "\n"), "\n") {
+			fmt.Println("    + " + line)
+		}
+	} else if plan.ProposedHeader != "" {
+		fmt.Println("  Header: unchanged")
+	}
+
+	if len(plan.MissingBookmarks) > 0 || len(plan.ExtraBookmarks) > 0 {
+		fmt.Printf("  Bookmarks: %d to add, %d not in config (removed only if 'Replace' is chosen)\n", len(plan.MissingBookmarks), len(plan.ExtraBookmarks))
+		for _, bookmark := range plan.MissingBookmarks {
+			fmt.Printf("    + %s (%s)\n", bookmark.DisplayName, bookmark.target())
+		}
+		for _, bookmark := range plan.ExtraBookmarks {
+			fmt.Printf("    ? %s (%s)\n", bookmark.DisplayName, existingBookmarkTarget(bookmark))
+		}
+	} else {
+		fmt.Println("  Bookmarks: unchanged")
+	}
+
+	fmt.Println()
+}
+
+// runReadOnly handles the flags and connection shared by 'plan' and 'audit'
+func runReadOnly(name string, description string, args []string, report func(ref string, plan *ChannelPlan)) int {
+	var conn mmConnection
+	var target targetOptions
+	var StepsList string
+
+	flags := newCommandFlags(name, description)
+	addConnectionFlags(flags, &conn)
+	addTargetFlags(flags, &target)
+	flags.StringVar(&StepsList, "steps", "pinned,header,bookmarks", "Comma-separated list of the steps to check")
+	parseCommandFlags(flags, args)
+
+	steps, err := parseApplySteps(StepsList)
+	if err != nil {
+		LogMessage(errorLevel, "Invalid -steps: "+err.Error())
+		exit(1)
+	}
+
+	resolveEnvironment(&conn, &target)
+	logParameters(conn, target)
+	connectionValid := validateConnection(&conn)
+	targetValid := validateTarget(target)
+	if !connectionValid || !targetValid {
+		exit(1)
+	}
+
+	mmClient := Connect(conn, target)
+
+	channels, failures := ResolveTargets(*mmClient, target)
+	skipped := AdaptToServer(*mmClient, &steps, channels)
+	drifted := 0
+	for _, channel := range channels {
+		SetLogChannel(channel.Ref, channel.ChannelID)
+		plan, err := PlanChannel(*mmClient, channel.ChannelID, channel.Config, steps)
+		if err != nil {
+			LogMessage(errorLevel, "Unable to check channel "+channel.Ref+": "+err.Error())
+			failures++
+			ClearLogChannel()
+			continue
+		}
+		if plan.HasDrift() {
+			drifted++
+		}
+		report(channel.Ref, plan)
+		ClearLogChannel()
+	}
+
+	if len(skipped) > 0 {
+		LogMessage(warningLevel, "Features not available on this server, and not checked: "+strings.Join(skipped, ", "))
+	}
+	if failures > 0 {
+		LogMessage(errorLevel, fmt.Sprintf("%d channels could not be checked", failures))
+		exit(14)
+	}
+	return drifted
+}
+
+// RunPlan implements the 'plan' command
+func RunPlan(args []string) {
+	drifted := runReadOnly("plan", "Shows what 'apply' would change in each channel, without changing anything.", args, PrintPlan)
+	LogMessage(infoLevel, fmt.Sprintf("%d channels would be changed", drifted))
+}
+
+// RunAudit implements the 'audit' command.  It exits with status 50 if any channel has drifted from its config.
+func RunAudit(args []string) {
+	drifted := runReadOnly("audit", "Reports channels which no longer match the config.  Exits with status 50 if any have drifted.", args, func(ref string, plan *ChannelPlan) {
+		if plan.HasDrift() {
+			LogMessage(warningLevel, "Channel "+ref+" has drifted: "+plan.DriftSummary())
+		} else {
+			LogMessage(infoLevel, "Channel "+ref+" matches the config")
+		}
+	})
+
+	if drifted > 0 {
+		LogMessage(warningLevel, fmt.Sprintf("%d channels have drifted from the config", drifted))
+		exit(50)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ServerProfile holds the connection details for one Mattermost instance
+type ServerProfile struct {
+	URL       string `json:"url"`
+	Port      string `json:"port,omitempty"`
+	Scheme    string `json:"scheme,omitempty"`
+	Token     string `json:"token,omitempty"`
+	TokenFile string `json:"token_file,omitempty"`
+}
+
+// profilesFile is the layout of the local profiles file
+type profilesFile struct {
+	Default  string                   `json:"default,omitempty"`
+	Profiles map[string]ServerProfile `json:"profiles"`
+}
+
+// mmctlCredentials is a single entry in the mmctl credentials file
+type mmctlCredentials struct {
+	Name        string `json:"name"`
+	InstanceURL string `json:"instanceUrl"`
+	Username    string `json:"username"`
+	AuthToken   string `json:"authToken"`
+	AuthMethod  string `json:"authMethod"`
+	Active      bool   `json:"active"`
+}
+
+// profilesFilename returns the location of the local profiles file, which can be moved with MM_PROFILES_FILE
+func profilesFilename() (string, error) {
+	if filename := getEnvWithDefault("MM_PROFILES_FILE", "").(string); filename != "" {
+		return filename, nil
+	}
+	userConfig, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userConfig, "mm-channel-header", "profiles.json"), nil
+}
+
+// mmctlConfigFilename returns the location of the credentials file written by 'mmctl auth login'
+func mmctlConfigFilename() (string, error) {
+	configHome := getEnvWithDefault("XDG_CONFIG_HOME", "").(string)
+	if configHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		configHome = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configHome, "mmctl", "config"), nil
+}
+
+// readPrivateJSON loads a JSON file holding credentials.  A missing file isn't an error, but one which other users
+// can read is logged as a warning.
+func readPrivateJSON(filename string, value interface{}) (bool, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	warnIfReadableByOthers(filename)
+
+	if err := json.Unmarshal(data, value); err != nil {
+		return false, fmt.Errorf("%s: %w", filename, err)
+	}
+	return true, nil
+}
+
+// profileFromInstanceURL checks the full URL stored by mmctl, which can be used as -url unchanged
+func profileFromInstanceURL(instanceURL string) (ServerProfile, error) {
+	parsed, err := url.Parse(instanceURL)
+	if err != nil {
+		return ServerProfile{}, err
+	}
+	if parsed.Hostname() == "" {
+		return ServerProfile{}, fmt.Errorf("invalid instance URL %q", instanceURL)
+	}
+	return ServerProfile{URL: instanceURL}, nil
+}
+
+// LoadProfile finds a named profile, first in the local profiles file and then in the mmctl credentials.  With no
+// name, the local file's default profile (if any) is used.  The second value describes where the profile came from.
+func LoadProfile(name string) (*ServerProfile, string, error) {
+	var local profilesFile
+	filename, err := profilesFilename()
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := readPrivateJSON(filename, &local); err != nil {
+		return nil, "", err
+	}
+
+	if name == "" {
+		name = local.Default
+		if name == "" {
+			return nil, "", nil
+		}
+	}
+	if profile, ok := local.Profiles[name]; ok {
+		return &profile, filename, nil
+	}
+
+	var credentials map[string]*mmctlCredentials
+	mmctlFilename, err := mmctlConfigFilename()
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := readPrivateJSON(mmctlFilename, &credentials); err != nil {
+		return nil, "", err
+	}
+	if entry, ok := credentials[name]; ok && entry != nil {
+		profile, err := profileFromInstanceURL(entry.InstanceURL)
+		if err != nil {
+			return nil, "", fmt.Errorf("mmctl credentials %q: %w", name, err)
+		}
+		profile.Token = entry.AuthToken
+		return &profile, mmctlFilename, nil
+	}
+
+	known := make([]string, 0, len(local.Profiles)+len(credentials))
+	for profileName := range local.Profiles {
+		known = append(known, profileName)
+	}
+	for profileName := range credentials {
+		known = append(known, profileName)
+	}
+	sort.Strings(known)
+	if len(known) == 0 {
+		return nil, "", fmt.Errorf("profile %q not found: no profiles in %s or %s", name, filename, mmctlFilename)
+	}
+	return nil, "", fmt.Errorf("profile %q not found (available: %s)", name, strings.Join(known, ", "))
+}
+
+// applyProfile fills in the connection details from the selected profile.  An explicit profile (-profile or
+// MM_PROFILE) takes precedence over the MM_* variables, but not over the command line, which is given as it was
+// before the environment was read.  The default profile only fills in what hasn't been supplied any other way.
+func applyProfile(conn *mmConnection, commandLine mmConnection, explicit bool) error {
+	profile, source, err := LoadProfile(conn.mmProfile)
+	if err != nil || profile == nil {
+		return err
+	}
+
+	// A default profile mustn't send its token to a server chosen some other way
+	if !explicit && conn.mmURL != "" && conn.mmURL != profile.URL {
+		DebugPrint("Ignoring the default profile, as a different URL was supplied")
+		return nil
+	}
+	DebugPrint("Using server profile from " + source)
+
+	if conn.mmURL == "" || (explicit && commandLine.mmURL == "") {
+		conn.mmURL = profile.URL
+	}
+	if conn.mmPort == "" || (explicit && commandLine.mmPort == "") {
+		conn.mmPort = profile.Port
+	}
+	if conn.mmScheme == "" || (explicit && commandLine.mmScheme == "") {
+		conn.mmScheme = profile.Scheme
+	}
+
+	// Only send the profile's token to the profile's own server
+	if conn.mmURL != profile.URL || conn.mmPort != profile.Port || conn.mmScheme != profile.Scheme {
+		LogMessage(warningLevel, "A different server was supplied than the one in the server profile, so the profile's token won't be used")
+		return nil
+	}
+
+	tokenOnCommandLine := commandLine.mmToken != "" || commandLine.mmTokenFile != "" || commandLine.mmTokenStdin
+	if conn.mmUsername == "" && (conn.mmToken == "" || (explicit && !tokenOnCommandLine)) {
+		switch {
+		case profile.Token != "":
+			conn.mmToken = profile.Token
+		case profile.TokenFile != "":
+			if conn.mmToken, err = readTokenFile(profile.TokenFile); err != nil {
+				return err
+			}
+		}
+		registerSecret(conn.mmToken)
+	}
+	return nil
+}
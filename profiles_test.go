@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyProfile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "profiles.json")
+	profiles := `{"default": "prod", "profiles": {"prod": {"url": "https://chat.example.com", "token": "profile-token"}}}`
+	if err := os.WriteFile(filename, []byte(profiles), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MM_PROFILES_FILE", filename)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	tests := []struct {
+		name        string
+		conn        mmConnection // after the environment has been read
+		commandLine mmConnection
+		explicit    bool
+		wantURL     string
+		wantToken   string
+	}{
+		{
+			name:      "explicit profile",
+			conn:      mmConnection{mmProfile: "prod"},
+			explicit:  true,
+			wantURL:   "https://chat.example.com",
+			wantToken: "profile-token",
+		},
+		{
+			name:        "explicit profile with a different -url",
+			conn:        mmConnection{mmProfile: "prod", mmURL: "https://other.example.com"},
+			commandLine: mmConnection{mmURL: "https://other.example.com"},
+			explicit:    true,
+			wantURL:     "https://other.example.com",
+		},
+		{
+			name:        "explicit profile with a different -port",
+			conn:        mmConnection{mmProfile: "prod", mmPort: "8065"},
+			commandLine: mmConnection{mmPort: "8065"},
+			explicit:    true,
+			wantURL:     "https://chat.example.com",
+		},
+		{
+			name:        "explicit profile with -token",
+			conn:        mmConnection{mmProfile: "prod", mmToken: "command-line-token"},
+			commandLine: mmConnection{mmToken: "command-line-token"},
+			explicit:    true,
+			wantURL:     "https://chat.example.com",
+			wantToken:   "command-line-token",
+		},
+		{
+			name:      "explicit profile over MM_URL and MM_TOKEN",
+			conn:      mmConnection{mmProfile: "prod", mmURL: "https://env.example.com", mmToken: "env-token"},
+			explicit:  true,
+			wantURL:   "https://chat.example.com",
+			wantToken: "profile-token",
+		},
+		{
+			name:      "default profile",
+			conn:      mmConnection{},
+			wantURL:   "https://chat.example.com",
+			wantToken: "profile-token",
+		},
+		{
+			name:        "default profile with a different -url",
+			conn:        mmConnection{mmURL: "https://other.example.com"},
+			commandLine: mmConnection{mmURL: "https://other.example.com"},
+			wantURL:     "https://other.example.com",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := test.conn
+			if err := applyProfile(&conn, test.commandLine, test.explicit); err != nil {
+				t.Fatal(err)
+			}
+			if conn.mmURL != test.wantURL {
+				t.Errorf("URL = %q, want %q", conn.mmURL, test.wantURL)
+			}
+			if conn.mmToken != test.wantToken {
+				t.Errorf("token = %q, want %q", conn.mmToken, test.wantToken)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// outputFormat is set with -result.  With "json", a RunResult is written to stdout when the run ends, and
+// everything else (logs and prompts) goes to stderr.
+var outputFormat = outputText
+
+// runResult collects everything a run does, when -result json is used
+var runResult *RunResult
+
+// RunResult is the machine-readable summary of a run
+type RunResult struct {
+	Command         string           `json:"command"`
+	Version         string           `json:"version"`
+	Status          string           `json:"status"`
+	ExitCode        int              `json:"exit_code"`
+	Started         time.Time        `json:"started"`
+	Finished        time.Time        `json:"finished"`
+	SkippedFeatures []string         `json:"skipped_features,omitempty"`
+	Warnings        []string         `json:"warnings,omitempty"`
+	Errors          []string         `json:"errors,omitempty"`
+	Channels        []*ChannelResult `json:"channels"`
+
+	current *ChannelResult
+}
+
+// ChannelResult records what a run did to one channel
+type ChannelResult struct {
+	Channel             string             `json:"channel"`
+	ChannelID           string             `json:"channel_id"`
+	Status              string             `json:"status"`
+	HeaderBefore        string             `json:"header_before"`
+	HeaderAfter         string             `json:"header_after"`
+	HeaderChanged       bool               `json:"header_changed"`
+	PinnedPostID        string             `json:"pinned_post_id,omitempty"`
+	PinnedPostPermalink string             `json:"pinned_post_permalink,omitempty"`
+	PinnedPostCreated   bool               `json:"pinned_post_created"`
+	CreatedBookmarkIDs  []string           `json:"created_bookmark_ids"`
+	DeletedBookmarkIDs  []string           `json:"deleted_bookmark_ids"`
+	AddedMembers        []MembershipChange `json:"added_members"`
+	Snapshot            string             `json:"snapshot,omitempty"`
+	Warnings            []string           `json:"warnings,omitempty"`
+	Errors              []string           `json:"errors,omitempty"`
+}
+
+// consoleOutput is where interactive prompts and debug listings are written, so they never mix with the JSON result
+// or a command's data
+func consoleOutput() io.Writer {
+	if outputFormat == outputJSON || logsToStderr {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// setOutputFormat is called as -result is parsed.  For JSON output, logs are moved to stderr when the logger is built.
+func setOutputFormat(value string) error {
+	switch value {
+	case outputText, outputJSON:
+		outputFormat = value
+	default:
+		return fmt.Errorf("unknown output format %q (expected %s or %s)", value, outputText, outputJSON)
+	}
+	return nil
+}
+
+// startRunResult begins collecting the result, if -result json was requested
+func startRunResult(command string) {
+	if outputFormat != outputJSON {
+		return
+	}
+	runResult = &RunResult{
+		Command:  command,
+		Version:  Version,
+		Started:  time.Now().UTC(),
+		Channels: []*ChannelResult{},
+	}
+}
+
+// startChannelResult begins recording a channel.  Warnings and errors logged from now on are attached to it.
+func startChannelResult(ref string, channelID string) {
+	if runResult == nil {
+		return
+	}
+	runResult.current = &ChannelResult{
+		Channel:            ref,
+		ChannelID:          channelID,
+		Status:             "failed",
+		CreatedBookmarkIDs: []string{},
+		DeletedBookmarkIDs: []string{},
+		AddedMembers:       []MembershipChange{},
+	}
+	runResult.Channels = append(runResult.Channels, runResult.current)
+}
+
+// recordLogMessage keeps warnings and errors for the result, against the channel being processed if there is one
+func recordLogMessage(level LogLevel, message string) {
+	if runResult == nil || (level != warningLevel && level != errorLevel) {
+		return
+	}
+	warnings, errors := &runResult.Warnings, &runResult.Errors
+	if runResult.current != nil {
+		warnings, errors = &runResult.current.Warnings, &runResult.current.Errors
+	}
+	if level == warningLevel {
+		*warnings = append(*warnings, message)
+	} else {
+		*errors = append(*errors, message)
+	}
+}
+
+// finishChannelResult fills in the channel's result from the snapshot taken by 'apply', the pinned post it used,
+// and the header as it is now
+func finishChannelResult(mmClient model.Client4, snapshot *ChannelSnapshot, linkToPinnedPost string, pinnedPostID string) {
+	if runResult == nil || runResult.current == nil {
+		return
+	}
+	result := runResult.current
+	defer func() { runResult.current = nil }()
+
+	result.HeaderBefore = snapshot.Header
+	result.HeaderAfter = snapshot.Header
+	result.HeaderChanged = snapshot.HeaderChanged
+	result.PinnedPostCreated = snapshot.CreatedPostID != ""
+	result.CreatedBookmarkIDs = append(result.CreatedBookmarkIDs, snapshot.CreatedBookmarkIDs...)
+	result.DeletedBookmarkIDs = append(result.DeletedBookmarkIDs, snapshot.DeletedBookmarkIDs...)
+	result.AddedMembers = append(result.AddedMembers, snapshot.AddedMembers...)
+	result.Snapshot = snapshot.filename
+	result.PinnedPostPermalink = linkToPinnedPost
+	result.PinnedPostID = pinnedPostID
+
+	if snapshot.HeaderChanged {
+		channel, _, err := mmClient.GetChannel(context.Background(), snapshot.ChannelID, "")
+		if err != nil {
+			LogMessage(warningLevel, "Unable to read the updated header for the result: "+err.Error())
+		} else {
+			result.HeaderAfter = channel.Header
+		}
+	}
+
+	result.Status = "unchanged"
+	if result.HeaderChanged || result.PinnedPostCreated || len(result.CreatedBookmarkIDs) > 0 || len(result.DeletedBookmarkIDs) > 0 || len(result.AddedMembers) > 0 {
+		result.Status = "changed"
+	}
+}
+
+// writeRunResult writes the result to stdout.  It's called once, either at the end of the run or when it exits
+// early, in which case the channel being processed is left marked as failed.
+func writeRunResult(exitCode int) {
+	if runResult == nil {
+		return
+	}
+	result := runResult
+	runResult = nil
+
+	result.ExitCode = exitCode
+	result.Finished = time.Now().UTC()
+	result.Status = "success"
+	for _, channel := range result.Channels {
+		if channel.Status == "failed" {
+			result.Status = "partial"
+		}
+	}
+	if exitCode != 0 {
+		result.Status = "failed"
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(result); err != nil {
+		LogMessage(errorLevel, "Unable to write the run result: "+err.Error())
+	}
+}
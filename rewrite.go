@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// urlRewriter finds a URL (or a regular expression) and, if a replacement was given, rewrites it
+type urlRewriter struct {
+	pattern     *regexp.Regexp
+	replacement string
+	literal     bool
+	rewrite     bool
+	found       int
+	rewritten   int
+}
+
+// newURLRewriter builds the rewriter for -find, -regex and -replace
+func newURLRewriter(find string, isRegex bool, replacement string, rewrite bool) (*urlRewriter, error) {
+	expression := regexp.QuoteMeta(find)
+	if isRegex {
+		expression = find
+	}
+	pattern, err := regexp.Compile(expression)
+	if err != nil {
+		return nil, err
+	}
+	return &urlRewriter{pattern: pattern, replacement: replacement, literal: !isRegex, rewrite: rewrite}, nil
+}
+
+// apply returns the text with every match replaced, and whether there were any matches
+func (r *urlRewriter) apply(text string) (string, bool) {
+	if !r.pattern.MatchString(text) {
+		return text, false
+	}
+	if r.literal {
+		return r.pattern.ReplaceAllLiteralString(text, r.replacement), true
+	}
+	return r.pattern.ReplaceAllString(text, r.replacement), true
+}
+
+// report prints a match, along with what it will be (or has been) changed to
+func (r *urlRewriter) report(ref string, where string, text string) {
+	r.found++
+	fmt.Printf("%s: %s\n", ref, where)
+	for _, match := range r.pattern.FindAllString(text, -1) {
+		if r.rewrite {
+			replaced, _ := r.apply(match)
+			fmt.Printf("    %s -> %s\n", match, replaced)
+		} else {
+			fmt.Printf("    %s\n", match)
+		}
+	}
+}
+
+// RewriteChannelURLs searches the channel's header, link bookmarks and the pinned posts created by this tool, and
+// updates each one in place if a replacement was given
+func RewriteChannelURLs(mmClient model.Client4, ref string, channelID string, rewriter *urlRewriter, dryRun bool) error {
+	ctx := context.Background()
+	etag := ""
+	update := rewriter.rewrite && !dryRun
+
+	channel, response, err := mmClient.GetChannel(ctx, channelID, etag)
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve channel: "+err.Error())
+		return err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(errorLevel, "Function call to GetChannel returned bad HTTP response")
+		return errors.New("bad HTTP response")
+	}
+
+	if header, matched := rewriter.apply(channel.Header); matched {
+		rewriter.report(ref, "channel header", channel.Header)
+		if update {
+			_, response, err := mmClient.PatchChannel(ctx, channelID, &model.ChannelPatch{Header: &header})
+			if err != nil {
+				LogMessage(errorLevel, "Failed to update channel header: "+err.Error())
+				return err
+			}
+			if response.StatusCode != 200 {
+				LogMessage(errorLevel, "Function call to PatchChannel returned bad HTTP response")
+				return errors.New("bad HTTP response")
+			}
+			rewriter.rewritten++
+		}
+	}
+
+	if bookmarksAvailable(mmClient) {
+		bookmarks, response, err := mmClient.ListChannelBookmarksForChannel(ctx, channelID, 0)
+
+		if err != nil {
+			LogMessage(errorLevel, "Failed to retrieve bookmarks: "+err.Error())
+			return err
+		}
+		if response.StatusCode != 200 && response.StatusCode != 201 {
+			LogMessage(errorLevel, "Function call to ListChannelBookmarksForChannel returned bad HTTP response")
+			return errors.New("bad HTTP response")
+		}
+
+		for _, bookmark := range bookmarks {
+			if bookmark.Type != model.ChannelBookmarkLink {
+				continue
+			}
+			linkURL, linkMatched := rewriter.apply(bookmark.LinkUrl)
+			imageURL, imageMatched := rewriter.apply(bookmark.ImageUrl)
+			if !linkMatched && !imageMatched {
+				continue
+			}
+
+			patch := &model.ChannelBookmarkPatch{}
+			if linkMatched {
+				rewriter.report(ref, "bookmark \""+bookmark.DisplayName+"\"", bookmark.LinkUrl)
+				patch.LinkUrl = &linkURL
+			}
+			if imageMatched {
+				rewriter.report(ref, "image for bookmark \""+bookmark.DisplayName+"\"", bookmark.ImageUrl)
+				patch.ImageUrl = &imageURL
+			}
+			if update {
+				_, response, err := mmClient.UpdateChannelBookmark(ctx, channelID, bookmark.Id, patch)
+				if err != nil {
+					LogMessage(errorLevel, "Failed to update bookmark "+bookmark.DisplayName+": "+err.Error())
+					return err
+				}
+				if response.StatusCode != 200 {
+					LogMessage(errorLevel, "Function call to UpdateChannelBookmark returned bad HTTP response")
+					return errors.New("bad HTTP response")
+				}
+				rewriter.rewritten++
+			}
+		}
+	}
+
+	pinnedPosts, response, err := mmClient.GetPinnedPosts(ctx, channelID, etag)
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve pinned posts: "+err.Error())
+		return err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(errorLevel, "Function call to GetPinnedPosts returned bad HTTP response")
+		return errors.New("bad HTTP response")
+	}
+
+	for _, postID := range pinnedPosts.Order {
+		post := pinnedPosts.Posts[postID]
+		if !strings.HasPrefix(post.Message, pinnedPostHeading) {
+			continue
+		}
+		message, matched := rewriter.apply(post.Message)
+		if !matched {
+			continue
+		}
+		rewriter.report(ref, "pinned post "+postID, post.Message)
+		if update {
+			_, response, err := mmClient.PatchPost(ctx, postID, &model.PostPatch{Message: &message})
+			if err != nil {
+				LogMessage(errorLevel, "Failed to update pinned post "+postID+": "+err.Error())
+				return err
+			}
+			if response.StatusCode != 200 {
+				LogMessage(errorLevel, "Function call to PatchPost returned bad HTTP response")
+				return errors.New("bad HTTP response")
+			}
+			rewriter.rewritten++
+		}
+	}
+
+	return nil
+}
+
+// RunFindURL implements the 'find-url' command, which finds a URL across channels and can rewrite it in place
+func RunFindURL(args []string) {
+	var conn mmConnection
+	var ChannelRef string
+	var InventoryFilename string
+	var Find string
+	var Replace string
+	var RegexFlag bool
+	var DryRunFlag bool
+
+	flags := newCommandFlags("find-url", "Finds a URL in the headers, bookmarks and pinned posts of channels, and optionally rewrites it.  Exits with status 59 if a channel can't be searched or updated.")
+	addConnectionFlags(flags, &conn)
+	flags.StringVar(&ChannelRef, "channel", "", "The channel ID, or team-name/channel-name, to search")
+	flags.StringVar(&InventoryFilename, "inventory", "", "CSV/TSV file listing the channels to search")
+	flags.StringVar(&Find, "find", "", "The URL (or part of one) to find")
+	flags.BoolVar(&RegexFlag, "regex", false, "Treat -find as a regular expression.  -replace can then use $1 etc. for its groups")
+	flags.StringVar(&Replace, "replace", "", "Rewrite every match with this")
+	flags.BoolVar(&DryRunFlag, "dry-run", false, "With -replace, show what would change without changing anything")
+	parseCommandFlags(flags, args)
+
+	rewrite := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "replace" {
+			rewrite = true
+		}
+	})
+
+	if Find == "" || (ChannelRef == "") == (InventoryFilename == "") {
+		LogMessage(errorLevel, "-find, and exactly one of -channel or -inventory, are required")
+		flags.Usage()
+		exit(1)
+	}
+	rewriter, err := newURLRewriter(Find, RegexFlag, Replace, rewrite)
+	if err != nil {
+		LogMessage(errorLevel, "Invalid -find: "+err.Error())
+		exit(1)
+	}
+
+	resolveEnvironment(&conn, nil)
+	if !validateConnection(&conn) {
+		flags.Usage()
+		exit(1)
+	}
+	mmClient := Connect(conn, targetOptions{})
+
+	refs := []string{ChannelRef}
+	if InventoryFilename != "" {
+		rows, err := LoadInventory(InventoryFilename)
+		if err != nil {
+			LogMessage(errorLevel, "Error processing inventory file: "+err.Error())
+			exit(13)
+		}
+		refs = refs[:0]
+		for _, row := range rows {
+			refs = append(refs, row.Channel)
+		}
+	}
+
+	failures := 0
+	for _, ref := range refs {
+		channelID, err := ResolveChannelID(*mmClient, ref)
+		if err != nil {
+			LogMessage(errorLevel, "Unable to find channel "+ref+": "+err.Error())
+			failures++
+			continue
+		}
+		SetLogChannel(ref, channelID)
+		if err := RewriteChannelURLs(*mmClient, ref, channelID, rewriter, DryRunFlag); err != nil {
+			failures++
+		}
+		ClearLogChannel()
+	}
+
+	fmt.Println()
+	switch {
+	case rewrite && !DryRunFlag:
+		LogMessage(infoLevel, fmt.Sprintf("Found in %d places across %d channels, %d updated", rewriter.found, len(refs), rewriter.rewritten))
+	default:
+		LogMessage(infoLevel, fmt.Sprintf("Found in %d places across %d channels", rewriter.found, len(refs)))
+	}
+
+	if failures > 0 {
+		LogMessage(errorLevel, fmt.Sprintf("%d channels could not be searched or updated", failures))
+		exit(59)
+	}
+}
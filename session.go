@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/x/term"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// mfaRequiredError is returned by the server when the account has MFA enabled and no (or a wrong) code was sent
+const mfaRequiredError = "api.user.check_user_mfa.bad_code.app_error"
+
+// sessionClient is set when we logged in with a username and password, so the session can be ended on exit
+var sessionClient *model.Client4
+
+// resolveLogin fills in the username, password and MFA code from the command line, files and environment
+func resolveLogin(conn *mmConnection) error {
+	if conn.mmUsername == "" {
+		conn.mmUsername = getEnvWithDefault("MM_USERNAME", "").(string)
+	}
+	if conn.mmUsername == "" {
+		return nil
+	}
+	if conn.mmToken != "" {
+		return errors.New("a token and a username can't both be supplied")
+	}
+
+	if conn.mmPasswordFile == "" {
+		conn.mmPasswordFile = getEnvWithDefault("MM_PASSWORD_FILE", "").(string)
+	}
+	if conn.mmPasswordFile != "" {
+		password, err := readTokenFile(conn.mmPasswordFile)
+		if err != nil {
+			return err
+		}
+		conn.mmPassword = password
+	} else {
+		conn.mmPassword = getEnvWithDefault("MM_PASSWORD", "").(string)
+	}
+	registerSecret(conn.mmPassword)
+
+	if conn.mmMFACode == "" {
+		conn.mmMFACode = getEnvWithDefault("MM_MFA_CODE", "").(string)
+	}
+	return nil
+}
+
+// ensurePassword prompts for the password if none was supplied and a user is available to type it in
+func ensurePassword(conn *mmConnection) {
+	if conn.mmPassword != "" || !term.IsTerminal(os.Stdin.Fd()) {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Password for %s: ", conn.mmUsername)
+	data, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		LogMessage(errorLevel, "Unable to read password: "+err.Error())
+		return
+	}
+	conn.mmPassword = string(data)
+	registerSecret(conn.mmPassword)
+}
+
+// promptForMFACode asks for a one-time MFA code, if a user is available to type it in
+func promptForMFACode() string {
+	if !term.IsTerminal(os.Stdin.Fd()) {
+		return ""
+	}
+	fmt.Fprint(os.Stderr, "MFA code: ")
+	data, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Login starts a session with a username and password, asking for an MFA code if the server needs one.  The
+// session is ended by EndSession.
+func Login(mmClient *model.Client4, conn mmConnection) error {
+	DebugPrint("Logging in as " + conn.mmUsername)
+
+	ctx := context.Background()
+	login := func(mfaCode string) (*model.Response, error) {
+		if mfaCode == "" {
+			_, response, err := mmClient.Login(ctx, conn.mmUsername, conn.mmPassword)
+			return response, err
+		}
+		_, response, err := mmClient.LoginWithMFA(ctx, conn.mmUsername, conn.mmPassword, mfaCode)
+		return response, err
+	}
+
+	response, err := login(conn.mmMFACode)
+	var appErr *model.AppError
+	if err != nil && conn.mmMFACode == "" && errors.As(err, &appErr) && appErr.Id == mfaRequiredError {
+		if code := promptForMFACode(); code != "" {
+			registerSecret(code)
+			response, err = login(code)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != 200 {
+		return errors.New("bad HTTP response")
+	}
+
+	registerSecret(mmClient.AuthToken)
+	sessionClient = mmClient
+	DebugPrint("Logged in")
+	return nil
+}
+
+// EndSession logs out of any session started by Login, so the session token can't be reused
+func EndSession() {
+	if sessionClient == nil {
+		return
+	}
+	client := sessionClient
+	sessionClient = nil
+
+	response, err := client.Logout(context.Background())
+	if err != nil {
+		LogMessage(warningLevel, "Failed to log out: "+err.Error())
+		return
+	}
+	if response.StatusCode != 200 {
+		LogMessage(warningLevel, "Function call to Logout returned bad HTTP response")
+		return
+	}
+	DebugPrint("Logged out")
+}
+
+// exit ends any login session before exiting, so that failed runs don't leave sessions behind, and writes the
+// run result if one was requested
+func exit(code int) {
+	EndSession()
+	writeRunResult(code)
+	os.Exit(code)
+}
+
+// RunCreateToken implements the 'create-token' command, which creates a personal access token (e.g. for a bot
+// account) so that later runs don't need a password
+func RunCreateToken(args []string) {
+	var conn mmConnection
+	var UserRef string
+	var Description string
+	var OutputFilename string
+
+	flags := newCommandFlags("create-token", "Creates a personal access token for a user or bot account, and prints it.")
+	addConnectionFlags(flags, &conn)
+	flags.StringVar(&UserRef, "user", "", "Username or email of the account to create the token for. [Default: the account used to connect]")
+	flags.StringVar(&Description, "description", "mm-channel-header", "Description stored with the token")
+	flags.StringVar(&OutputFilename, "output", "", "Write the token to this file (mode 0600), for use with -token-file. [Default: stdout]")
+	parseCommandFlags(flags, args)
+	if OutputFilename == "" {
+		sendLogsToStderr()
+	}
+
+	resolveEnvironment(&conn, nil)
+	if !validateConnection(&conn) {
+		flags.Usage()
+		exit(1)
+	}
+
+	mmClient := Connect(conn, targetOptions{})
+	ctx := context.Background()
+	etag := ""
+
+	var user *model.User
+	var response *model.Response
+	var err error
+	switch {
+	case UserRef == "":
+		user, response, err = mmClient.GetMe(ctx, etag)
+	case strings.Contains(UserRef, "@"):
+		user, response, err = mmClient.GetUserByEmail(ctx, UserRef, etag)
+	default:
+		user, response, err = mmClient.GetUserByUsername(ctx, strings.TrimPrefix(UserRef, "@"), etag)
+	}
+	if err != nil {
+		LogMessage(errorLevel, "Unable to find user: "+err.Error())
+		exit(54)
+	}
+	if response.StatusCode != 200 {
+		LogMessage(errorLevel, "Function call to get the user returned bad HTTP response")
+		exit(54)
+	}
+
+	token, response, err := mmClient.CreateUserAccessToken(ctx, user.Id, Description)
+	if err != nil {
+		LogMessage(errorLevel, "Unable to create token for "+user.Username+": "+err.Error())
+		exit(54)
+	}
+	if response.StatusCode != 200 && response.StatusCode != 201 {
+		LogMessage(errorLevel, "Function call to CreateUserAccessToken returned bad HTTP response")
+		exit(54)
+	}
+
+	// Only the token goes to stdout, so it can be captured by a script
+	if OutputFilename == "" {
+		fmt.Println(token.Token)
+		return
+	}
+	if err := os.WriteFile(OutputFilename, []byte(token.Token+"\n"), 0600); err != nil {
+		LogMessage(errorLevel, "Unable to write token: "+err.Error())
+		exit(54)
+	}
+	LogMessage(infoLevel, "Created token "+token.Id+" for "+user.Username+", written to "+OutputFilename)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// TestCreateTokenPrintsOnlyToken checks that warnings (here for -token on the command line) stay off stdout, so
+// the token can be captured by a script
+func TestCreateTokenPrintsOnlyToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v4/users/me":
+			json.NewEncoder(w).Encode(&model.User{Id: "user-id", Username: "bot"})
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v4/users/user-id/tokens":
+			json.NewEncoder(w).Encode(&model.UserAccessToken{Id: "token-id", Token: "new-token", UserId: "user-id"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	stdout, stderr := os.Stdout, os.Stderr
+	stdoutReader, stdoutWriter, _ := os.Pipe()
+	stderrReader, stderrWriter, _ := os.Pipe()
+	os.Stdout, os.Stderr = stdoutWriter, stderrWriter
+	defer func() {
+		os.Stdout, os.Stderr = stdout, stderr
+		logsToStderr = false
+		configureLogging()
+	}()
+
+	RunCreateToken([]string{"-url", server.URL, "-token", "admin-token"})
+
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	printed, _ := io.ReadAll(stdoutReader)
+	logged, _ := io.ReadAll(stderrReader)
+
+	if string(printed) != "new-token\n" {
+		t.Errorf("stdout = %q, want only the token", printed)
+	}
+	if !strings.Contains(string(logged), "Passing -token on the command line") {
+		t.Errorf("stderr = %q, want the -token warning", logged)
+	}
+}
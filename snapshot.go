@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	snapshotTimeFormat       = "20060102T150405Z"
+	snapshotRolledBackSuffix = ".rolledback"
+)
+
+// snapshotDir can be set from the command line, and otherwise defaults to a directory under the user config directory
+var snapshotDir string
+
+// ChannelSnapshot records the state of a channel before 'apply' changed it, along with everything that the run
+// created, so that 'rollback' can put it back the way it was
+type ChannelSnapshot struct {
+	ChannelID          string                               `json:"channel_id"`
+	Taken              time.Time                            `json:"taken"`
+	Header             string                               `json:"header"`
+	Bookmarks          []*model.ChannelBookmarkWithFileInfo `json:"bookmarks"`
+	HeaderChanged      bool                                 `json:"header_changed"`
+	CreatedPostID      string                               `json:"created_post_id,omitempty"`
+	CreatedBookmarkIDs []string                             `json:"created_bookmark_ids,omitempty"`
+	DeletedBookmarkIDs []string                             `json:"deleted_bookmark_ids,omitempty"`
+	AddedMembers       []MembershipChange                   `json:"added_members,omitempty"`
+
+	filename string
+}
+
+// snapshotChannelDir returns the directory holding the snapshots for a channel
+func snapshotChannelDir(channelID string) (string, error) {
+	base := snapshotDir
+	if base == "" {
+		userConfig, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(userConfig, "mm-channel-header", "snapshots")
+	}
+	return filepath.Join(base, channelID), nil
+}
+
+// TakeSnapshot records the current header and bookmarks for a channel.  Nothing is written until the run
+// changes something.
+func TakeSnapshot(mmClient model.Client4, channelID string) (*ChannelSnapshot, error) {
+	DebugPrint("Taking snapshot of channel " + channelID)
+
+	ctx := context.Background()
+	etag := ""
+
+	channel, response, err := mmClient.GetChannel(ctx, channelID, etag)
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve channel: "+err.Error())
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		LogMessage(errorLevel, "Function call to GetChannel returned bad HTTP response")
+		return nil, errors.New("bad HTTP response")
+	}
+
+	snapshot := &ChannelSnapshot{
+		ChannelID: channelID,
+		Taken:     time.Now().UTC(),
+		Header:    channel.Header,
+	}
+
+	if bookmarksAvailable(mmClient) {
+		bookmarks, response, err := mmClient.ListChannelBookmarksForChannel(ctx, channelID, 0)
+		if err != nil || (response.StatusCode != 200 && response.StatusCode != 201) {
+			LogMessage(warningLevel, "Unable to record existing bookmarks - they will not be restored by a rollback")
+		} else {
+			snapshot.Bookmarks = bookmarks
+		}
+	}
+
+	dir, err := snapshotChannelDir(channelID)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.filename = filepath.Join(dir, snapshot.Taken.Format(snapshotTimeFormat)+".json")
+
+	return snapshot, nil
+}
+
+// Save writes the snapshot to disk.  It's called after every change, so that a run which aborts part way
+// through can still be rolled back.
+func (s *ChannelSnapshot) Save() {
+	if s == nil || s.filename == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.filename), 0700)
+	}
+	if err == nil {
+		err = os.WriteFile(s.filename, data, 0600)
+	}
+	if err != nil {
+		LogMessage(warningLevel, "Unable to save snapshot for rollback: "+err.Error())
+		return
+	}
+	DebugPrint("Snapshot saved to " + s.filename)
+}
+
+// LoadSnapshot reads a snapshot file written by an earlier run
+func LoadSnapshot(filename string) (*ChannelSnapshot, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot ChannelSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	snapshot.filename = filename
+	return &snapshot, nil
+}
+
+// LatestSnapshot returns the most recent snapshot for a channel which hasn't already been rolled back
+func LatestSnapshot(channelID string) (*ChannelSnapshot, error) {
+	dir, err := snapshotChannelDir(channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no snapshots found for channel " + channelID)
+	}
+	sort.Strings(files)
+
+	return LoadSnapshot(files[len(files)-1])
+}
+
+// RestoreSnapshot removes whatever the run created, and puts back the header and any link bookmarks it deleted
+func RestoreSnapshot(mmClient model.Client4, snapshot *ChannelSnapshot) error {
+	ctx := context.Background()
+	channelID := snapshot.ChannelID
+
+	if snapshot.CreatedPostID != "" {
+		LogMessage(infoLevel, "Deleting pinned post "+snapshot.CreatedPostID)
+		response, err := mmClient.DeletePost(ctx, snapshot.CreatedPostID)
+		if err != nil && (response == nil || response.StatusCode != 404) {
+			LogMessage(errorLevel, "Failed to delete pinned post: "+err.Error())
+			return err
+		}
+	}
+
+	if snapshot.HeaderChanged {
+		LogMessage(infoLevel, "Restoring channel header")
+		header := snapshot.Header
+		_, response, err := mmClient.PatchChannel(ctx, channelID, &model.ChannelPatch{Header: &header})
+		if err != nil {
+			LogMessage(errorLevel, "Failed to restore channel header: "+err.Error())
+			return err
+		}
+		if response.StatusCode != 200 {
+			LogMessage(errorLevel, "Function call to PatchChannel returned bad HTTP response")
+			return errors.New("bad HTTP response")
+		}
+	}
+
+	for _, bookmarkID := range snapshot.CreatedBookmarkIDs {
+		DebugPrint("Deleting bookmark " + bookmarkID)
+		_, response, err := mmClient.DeleteChannelBookmark(ctx, channelID, bookmarkID)
+		if err != nil && (response == nil || response.StatusCode != 404) {
+			LogMessage(errorLevel, "Failed to delete bookmark "+bookmarkID+": "+err.Error())
+			return err
+		}
+	}
+
+	if snapshot.Bookmarks == nil {
+		return nil
+	}
+
+	current, response, err := mmClient.ListChannelBookmarksForChannel(ctx, channelID, 0)
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve bookmarks: "+err.Error())
+		return err
+	}
+	if response.StatusCode != 200 && response.StatusCode != 201 {
+		LogMessage(errorLevel, "Function call to ListChannelBookmarksForChannel returned bad HTTP response")
+		return errors.New("bad HTTP response")
+	}
+
+	existing := make(map[string]bool, len(current))
+	for _, bookmark := range current {
+		existing[bookmark.Id] = true
+	}
+
+	for _, bookmark := range snapshot.Bookmarks {
+		if existing[bookmark.Id] {
+			continue
+		}
+		// A bookmark's file is deleted along with it, so there's nothing left to attach a new bookmark to
+		if bookmark.Type == model.ChannelBookmarkFile {
+			LogMessage(warningLevel, "File bookmark "+bookmark.DisplayName+" can't be restored, as its file was deleted with it.  Please re-add it by hand.")
+			continue
+		}
+		LogMessage(infoLevel, "Restoring bookmark: "+bookmark.DisplayName)
+		restored := &model.ChannelBookmark{
+			ChannelId:   channelID,
+			DisplayName: bookmark.DisplayName,
+			LinkUrl:     bookmark.LinkUrl,
+			ImageUrl:    bookmark.ImageUrl,
+			Emoji:       bookmark.Emoji,
+			Type:        bookmark.Type,
+		}
+		_, response, err := mmClient.CreateChannelBookmark(ctx, restored)
+		if err != nil {
+			LogMessage(errorLevel, "Failed to restore bookmark: "+err.Error())
+			return err
+		}
+		if response.StatusCode != 200 && response.StatusCode != 201 {
+			LogMessage(errorLevel, "Function call to CreateChannelBookmark returned bad HTTP response")
+			return errors.New("bad HTTP response")
+		}
+	}
+
+	return nil
+}
+
+// RunRollback implements the 'rollback' command
+func RunRollback(args []string) {
+	var conn mmConnection
+	var ChannelRef string
+	var SnapshotFilename string
+	var YesFlag bool
+
+	flags := newCommandFlags("rollback", "Undoes the most recent 'apply' to a channel, using the snapshot it recorded.")
+	addConnectionFlags(flags, &conn)
+	flags.StringVar(&ChannelRef, "channel", "", "The channel ID, or team-name/channel-name, to roll back")
+	flags.StringVar(&SnapshotFilename, "snapshot", "", "A specific snapshot file to restore. [Default: the latest for the channel]")
+	flags.StringVar(&snapshotDir, "snapshot-dir", "", "Directory holding snapshots. [Default: user config directory]")
+	flags.BoolVar(&YesFlag, "yes", false, "Don't ask for confirmation")
+	parseCommandFlags(flags, args)
+
+	resolveEnvironment(&conn, nil)
+	if !validateConnection(&conn) {
+		exit(1)
+	}
+	if ChannelRef == "" && SnapshotFilename == "" {
+		LogMessage(errorLevel, "Either -channel or -snapshot is required.")
+		flags.Usage()
+		exit(1)
+	}
+
+	mmClient := Connect(conn, targetOptions{})
+
+	var snapshot *ChannelSnapshot
+	var err error
+	if SnapshotFilename != "" {
+		snapshot, err = LoadSnapshot(SnapshotFilename)
+	} else {
+		var channelID string
+		channelID, err = ResolveChannelID(*mmClient, ChannelRef)
+		if err == nil {
+			snapshot, err = LatestSnapshot(channelID)
+		}
+	}
+	if err != nil {
+		LogMessage(errorLevel, "Unable to load snapshot: "+err.Error())
+		exit(51)
+	}
+
+	LogMessage(infoLevel, fmt.Sprintf("Rolling back channel %s to %s", snapshot.ChannelID, snapshot.Taken.Format(time.RFC3339)))
+
+	if !YesFlag {
+		fmt.Printf("This will delete %d bookmark(s) and %s created by that run.  Continue? (Press Y to confirm, or any other key to abort)", len(snapshot.CreatedBookmarkIDs), describeCreatedPost(snapshot.CreatedPostID))
+		reader := bufio.NewReader(os.Stdin)
+		input, err := reader.ReadString('\n')
+		if err != nil || strings.ToLower(strings.TrimSpace(input)) != "y" {
+			LogMessage(infoLevel, "Rollback aborted")
+			return
+		}
+	}
+
+	if err := RestoreSnapshot(*mmClient, snapshot); err != nil {
+		LogMessage(errorLevel, "Rollback failed.  Aborting.")
+		exit(52)
+	}
+
+	// Mark the snapshot as used, so the next rollback picks up the one before it
+	if err := os.Rename(snapshot.filename, snapshot.filename+snapshotRolledBackSuffix); err != nil {
+		LogMessage(warningLevel, "Unable to mark snapshot as rolled back: "+err.Error())
+	}
+
+	LogMessage(infoLevel, "Rollback complete")
+}
+
+func describeCreatedPost(postID string) string {
+	if postID == "" {
+		return "no pinned post"
+	}
+	return "the pinned post"
+}
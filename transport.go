@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// resolveTLSEnvironment fills in any TLS options not supplied on the command line from environment variables
+func resolveTLSEnvironment(conn *mmConnection) error {
+	if conn.caCertFile == "" {
+		conn.caCertFile = getEnvWithDefault("MM_CA_CERT", "").(string)
+	}
+	if conn.clientCertFile == "" {
+		conn.clientCertFile = getEnvWithDefault("MM_CLIENT_CERT", "").(string)
+	}
+	if conn.clientKeyFile == "" {
+		conn.clientKeyFile = getEnvWithDefault("MM_CLIENT_KEY", "").(string)
+	}
+	if !conn.insecureSkipVerify {
+		if value := getEnvWithDefault("MM_INSECURE_SKIP_VERIFY", "").(string); value != "" {
+			insecure, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid MM_INSECURE_SKIP_VERIFY %q", value)
+			}
+			conn.insecureSkipVerify = insecure
+		}
+	}
+
+	if (conn.clientCertFile == "") != (conn.clientKeyFile == "") {
+		return errors.New("-client-cert and -client-key must be supplied together")
+	}
+	return nil
+}
+
+// NewHTTPClient builds the HTTP client used to talk to Mattermost.  Proxies are taken from HTTPS_PROXY,
+// HTTP_PROXY and NO_PROXY.
+func NewHTTPClient(conn mmConnection) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if conn.caCertFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			DebugPrint("Unable to load the system CA certificates, so only " + conn.caCertFile + " will be trusted: " + err.Error())
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(conn.caCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", conn.caCertFile)
+		}
+		tlsConfig.RootCAs = pool
+		DebugPrint("Trusting CA certificates from " + conn.caCertFile)
+	}
+
+	if conn.clientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(conn.clientCertFile, conn.clientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		DebugPrint("Using client certificate " + conn.clientCertFile)
+	}
+
+	if conn.insecureSkipVerify {
+		LogMessage(warningLevel, "Server certificate verification is disabled.  Don't use -insecure-skip-verify in production.")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// uploadCacheFile returns the file recording what has been uploaded to a channel, keyed by the SHA-256 of the
+// content, so unchanged files aren't uploaded again
+func uploadCacheFile(channelID string) (string, error) {
+	if cacheDir != "" {
+		return filepath.Join(cacheDir, "uploads", channelID+".json"), nil
+	}
+	userCache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userCache, "mm-channel-header", "uploads", channelID+".json"), nil
+}
+
+// readUploadCache returns the file IDs uploaded to the channel, by content hash
+func readUploadCache(channelID string) map[string]string {
+	uploads := make(map[string]string)
+	filename, err := uploadCacheFile(channelID)
+	if err != nil {
+		return uploads
+	}
+	if data, err := os.ReadFile(filename); err == nil {
+		if err := json.Unmarshal(data, &uploads); err != nil {
+			LogMessage(warningLevel, "Ignoring unreadable upload cache "+filename+": "+err.Error())
+		}
+	}
+	return uploads
+}
+
+// saveUploadCache records the file IDs uploaded to the channel
+func saveUploadCache(channelID string, uploads map[string]string) {
+	filename, err := uploadCacheFile(channelID)
+	if err == nil {
+		var data []byte
+		data, err = json.MarshalIndent(uploads, "", "\t")
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(filename), 0700)
+		}
+		if err == nil {
+			err = os.WriteFile(filename, data, 0600)
+		}
+	}
+	if err != nil {
+		LogMessage(warningLevel, "Unable to save upload cache - files will be uploaded again next time: "+err.Error())
+	}
+}
+
+// uploadBookmarkFile uploads the file to the channel as a bookmark file.  Files for channel bookmarks must be owned
+// by the bookmark rather than the user uploading them, which Client4.UploadFile can't do.
+func uploadBookmarkFile(mmClient model.Client4, channelID string, filename string, data []byte) (*model.FileUploadResponse, *model.Response, error) {
+	route := fmt.Sprintf("/files?channel_id=%s&filename=%s&bookmark=true", url.QueryEscape(channelID), url.QueryEscape(filename))
+	return mmClient.DoUploadFile(context.Background(), route, data, http.DetectContentType(data))
+}
+
+// UploadChannelFile uploads a local file to the channel for a bookmark and returns its file ID.  If the same content
+// was uploaded before and the file is still on the server, that file is used instead.
+func UploadChannelFile(mmClient model.Client4, channelID string, path string) (string, error) {
+	ctx := context.Background()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		LogMessage(errorLevel, "Failed to read "+path+": "+err.Error())
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	uploads := readUploadCache(channelID)
+	if fileID, found := uploads[hash]; found {
+		info, _, err := mmClient.GetFileInfo(ctx, fileID)
+		if err == nil && info.DeleteAt == 0 && info.CreatorId == model.BookmarkFileOwner {
+			DebugPrint(path + " hasn't changed since it was uploaded as " + fileID + ", so it won't be uploaded again")
+			return fileID, nil
+		}
+		DebugPrint("Previous upload of " + path + " is no longer on the server")
+	}
+
+	LogMessage(infoLevel, "Uploading "+path)
+	uploaded, response, err := uploadBookmarkFile(mmClient, channelID, filepath.Base(path), data)
+
+	if err != nil {
+		LogMessage(errorLevel, "Failed to upload "+path+": "+err.Error())
+		return "", err
+	}
+	if response.StatusCode != 201 || len(uploaded.FileInfos) == 0 {
+		LogMessage(errorLevel, "Function call to UploadFile returned bad HTTP response")
+		return "", errors.New("bad HTTP response")
+	}
+
+	fileID := uploaded.FileInfos[0].Id
+	uploads[hash] = fileID
+	saveUploadCache(channelID, uploads)
+	return fileID, nil
+}
+
+// BookmarkImageURL returns the icon for a link bookmark, uploading a local image to the channel if one is given.
+// The image is uploaded as a bookmark file, so it belongs to the channel rather than to us, and can be read by
+// anyone who can read the channel.  Its public link is used when public links are enabled, and otherwise its
+// preview URL on the server, which loads for members of the channel who are logged in.
+func BookmarkImageURL(mmClient model.Client4, channelID string, bookmark Bookmark) (string, error) {
+	if bookmark.Image == "" {
+		return bookmark.ImageURL, nil
+	}
+
+	fileID, err := UploadChannelFile(mmClient, channelID, bookmark.Image)
+	if err != nil {
+		return "", err
+	}
+
+	link, _, err := mmClient.GetFileLink(context.Background(), fileID)
+	if err == nil && link != "" {
+		return link, nil
+	}
+	DebugPrint("No public link for " + bookmark.Image + ", so its server URL will be used")
+	return PermalinkBaseURL(mmClient) + "/api/v4/files/" + fileID + "/preview", nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+var emojiPattern = regexp.MustCompile(`^:?[a-z0-9_+\-]+:?$`)
+
+// placeholderPermalink stands in for the link to the pinned post, which is only known once the post is created
+const placeholderPermalink = "https://mattermost.example.com/team-name/pl/abcdefghijklmnopqrstuvwxyz"
+
+// validateURL checks that a link will be clickable in Mattermost
+func validateURL(value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	switch parsed.Scheme {
+	case "http", "https":
+		if parsed.Host == "" {
+			return fmt.Errorf("missing host")
+		}
+	case "mailto":
+	default:
+		return fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+	}
+	return nil
+}
+
+// ValidateConfig returns a list of problems that would cause 'apply' to fail, or produce broken output
+func ValidateConfig(config *Config) []string {
+	var problems []string
+
+	for i, person := range config.Team {
+		if person.Name == "" && person.Email == "" && person.Username == "" {
+			problems = append(problems, fmt.Sprintf("team entry %d has no name, email or username", i+1))
+		}
+	}
+
+	checkLink := func(section string, name string, link string, emoji string) {
+		if name == "" {
+			problems = append(problems, fmt.Sprintf("%s entry for %s has no display name", section, link))
+		}
+		if err := validateURL(link); err != nil {
+			problems = append(problems, fmt.Sprintf("%s entry %q has an invalid URL %q: %s", section, name, link, err.Error()))
+		}
+		if emoji != "" && !emojiPattern.MatchString(emoji) {
+			problems = append(problems, fmt.Sprintf("%s entry %q has an invalid emoji %q", section, name, emoji))
+		}
+	}
+
+	for _, link := range config.HeaderLinks {
+		checkLink("header", link.DisplayName, link.LinkURL, "")
+	}
+	if config.DefaultEmoji != "" && !emojiPattern.MatchString(config.DefaultEmoji) {
+		problems = append(problems, fmt.Sprintf("default_emoji %q is invalid", config.DefaultEmoji))
+	}
+	if config.EmojiDir != "" {
+		if info, err := os.Stat(config.EmojiDir); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("emoji_dir %q isn't a directory", config.EmojiDir))
+		}
+	}
+
+	orders := make(map[int]string)
+	for _, bookmark := range config.Bookmarks {
+		switch bookmark.Type {
+		case "", bookmarkTypeLink:
+			checkLink("bookmark", bookmark.DisplayName, bookmark.LinkURL, bookmark.Emoji)
+			if bookmark.ImageURL != "" && bookmark.Image != "" {
+				problems = append(problems, fmt.Sprintf("bookmark entry %q has both an image_url and an image", bookmark.DisplayName))
+			}
+			if bookmark.ImageURL != "" {
+				if err := validateURL(bookmark.ImageURL); err != nil || strings.HasPrefix(bookmark.ImageURL, "mailto:") {
+					problems = append(problems, fmt.Sprintf("bookmark entry %q has an invalid image_url %q", bookmark.DisplayName, bookmark.ImageURL))
+				}
+			}
+			if bookmark.Image != "" {
+				if _, err := os.Stat(bookmark.Image); err != nil {
+					problems = append(problems, fmt.Sprintf("bookmark entry %q has an unreadable image: %s", bookmark.DisplayName, err.Error()))
+				}
+			}
+		case bookmarkTypeFile:
+			if _, err := os.Stat(bookmark.Path); err != nil {
+				problems = append(problems, fmt.Sprintf("bookmark entry %q has an unreadable file: %s", bookmark.DisplayName, err.Error()))
+			}
+			if bookmark.ImageURL != "" || bookmark.Image != "" {
+				problems = append(problems, fmt.Sprintf("bookmark entry %q is a file, so it can't have an image", bookmark.DisplayName))
+			}
+			if bookmark.Emoji != "" && !emojiPattern.MatchString(bookmark.Emoji) {
+				problems = append(problems, fmt.Sprintf("bookmark entry %q has an invalid emoji %q", bookmark.DisplayName, bookmark.Emoji))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("bookmark entry %q has unknown type %q", bookmark.DisplayName, bookmark.Type))
+		}
+		if bookmark.Order < 0 {
+			problems = append(problems, fmt.Sprintf("bookmark entry %q has a negative order %d", bookmark.DisplayName, bookmark.Order))
+		} else if other, found := orders[bookmark.Order]; found && bookmark.Order > 0 {
+			problems = append(problems, fmt.Sprintf("bookmark entries %q and %q both have order %d", other, bookmark.DisplayName, bookmark.Order))
+		} else {
+			orders[bookmark.Order] = bookmark.DisplayName
+		}
+	}
+	for _, resource := range config.Resources {
+		checkLink("pinned post", resource.DisplayName, resource.URL, "")
+	}
+
+	// Check the sizes with the pinned post link in place, as that's what will actually be sent
+	withLink := withPinnedPostLink(config, placeholderPermalink)
+	if len(withLink.HeaderLinks) > 0 {
+		if length := utf8.RuneCountInString(BuildChannelHeader(withLink)); length > model.ChannelHeaderMaxRunes {
+			problems = append(problems, fmt.Sprintf("channel header would be %d characters, but Mattermost allows %d", length, model.ChannelHeaderMaxRunes))
+		}
+	}
+	if len(config.Resources) > 0 {
+		if length := utf8.RuneCountInString(BuildPinnedPostMessage(*config)); length > model.PostMessageMaxRunesV2 {
+			problems = append(problems, fmt.Sprintf("pinned post would be %d characters, but Mattermost allows %d", length, model.PostMessageMaxRunesV2))
+		}
+	}
+
+	return problems
+}
+
+// RunValidate implements the 'validate' command.  A connection is only needed for configs stored in Mattermost.
+func RunValidate(args []string) {
+	var conn mmConnection
+	var target targetOptions
+
+	flags := newCommandFlags("validate", "Checks a config file (and optionally every row of an inventory) for problems.  Exits with status 17 if any are found.")
+	addConnectionFlags(flags, &conn)
+	addConfigFlags(flags, &target)
+	flags.StringVar(&target.Inventory, "inventory", "", "CSV/TSV file whose rows should each be rendered and checked")
+	parseCommandFlags(flags, args)
+
+	resolveEnvironment(&conn, &target)
+	if isMattermostConfig(target.ConfigFilename) {
+		if !validateConnection(&conn) {
+			exit(1)
+		}
+		Connect(conn, target)
+	}
+
+	type configToCheck struct {
+		label    string
+		filename string
+		vars     map[string]string
+	}
+	var configs []configToCheck
+
+	if target.Inventory == "" {
+		configs = append(configs, configToCheck{label: target.ConfigFilename, filename: target.ConfigFilename})
+	} else {
+		rows, err := LoadInventory(target.Inventory)
+		if err != nil {
+			LogMessage(errorLevel, "Error processing inventory file: "+err.Error())
+			exit(13)
+		}
+		for _, row := range rows {
+			filename, err := InventoryConfigFilename(row, target.ConfigFilename)
+			if err != nil {
+				LogMessage(errorLevel, fmt.Sprintf("Inventory line %d: unable to resolve config filename: %s", row.Line, err.Error()))
+				exit(17)
+			}
+			configs = append(configs, configToCheck{
+				label:    fmt.Sprintf("inventory line %d (%s)", row.Line, filename),
+				filename: filename,
+				vars:     row.Variables,
+			})
+		}
+	}
+
+	failed := 0
+	for _, check := range configs {
+		config, err := LoadConfig(check.filename, check.vars)
+		if err != nil {
+			LogMessage(errorLevel, check.label+": "+err.Error())
+			failed++
+			continue
+		}
+
+		problems := ValidateConfig(config)
+		for _, problem := range problems {
+			LogMessage(errorLevel, check.label+": "+problem)
+		}
+		if len(problems) > 0 {
+			failed++
+			continue
+		}
+		LogMessage(infoLevel, check.label+": OK")
+	}
+
+	if failed > 0 {
+		LogMessage(errorLevel, fmt.Sprintf("%d of %d configs have problems", failed, len(configs)))
+		exit(17)
+	}
+}